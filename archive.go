@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var archiveExtensions = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// archiveExt returns the archive extension of the file or "" if it is no archive
+func archiveExt(file string) string {
+	lower := strings.ToLower(file)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// archiveEntry is an image read from the archive
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// walkArchive calls accept for the name of every file in the archive and sends
+// the data of the accepted files to the channel, which is closed at the end
+func walkArchive(file string, accept func(name string) bool, entries chan<- archiveEntry) error {
+	defer close(entries)
+	if archiveExt(file) == ".zip" {
+		r, err := zip.OpenReader(file)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() || !accept(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			entries <- archiveEntry{name: f.Name, data: data}
+		}
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if ext := archiveExt(file); ext == ".tar.gz" || ext == ".tgz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !accept(hdr.Name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		entries <- archiveEntry{name: hdr.Name, data: data}
+	}
+}
+
+// archiveEntryName cleans the name of an entry, names that leave the archive
+// (../x.png or /etc/x.png) return ""
+func archiveEntryName(name string) string {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return ""
+	}
+	return name
+}
+
+// captionArchive captions the images in the ZIP or TAR file of PATH without
+// extracting it. The captions are written to a manifest next to the archive,
+// or mirrored into --output-dir.
+func captionArchive(ol *hostPool, args args) error {
+	// the .capollama.toml of the folder of the archive applies to all its images
+	args, err := overrides.apply(args, args.Path, "")
+	if err != nil {
+		return err
+	}
+	var m *manifest
+	if args.OutputDir == "" {
+		m, err = openManifest(manifestFile(args), args.DryRun)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+	}
+	outputPath := func(name string) string {
+		return filepath.Join(args.OutputDir, filepath.FromSlash(outputFile(name, ".txt")))
+	}
+
+	var b backlog
+	accept := func(name string) bool {
+		name = archiveEntryName(name)
+		if name == "" || !isImageFile(name) {
+			return false
+		}
+		b.Images++
+		if !args.Force {
+			if m != nil && m.has(name) || m == nil && fileExists(outputPath(name)) {
+				b.Existing++
+				return false
+			}
+		}
+		return true
+	}
+
+	entries := make(chan archiveEntry)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				name := archiveEntryName(entry.name)
+				// the path of the entry in logs and results is inside the archive
+				display := args.Path + "/" + name
+				start := time.Now()
+				caption, err := captionBytes(ol, args, display, entry.data)
+				stats.imageDone(display, time.Since(start), err)
+				if errors.Is(err, errCorruptImage) {
+					logError("Skipping %s: %v", logPath(display), err)
+					stats.corrupted(display)
+					continue
+				}
+				if err != nil {
+					logError("Failed %s: %v", logPath(display), err)
+					continue
+				}
+				printResult(args, result{Path: display, Caption: caption}, args.Path)
+				notifyImage(args, result{Path: display, Caption: caption}, nil, time.Since(start))
+				if m != nil {
+					err = m.add(manifestEntry{Path: name, Caption: caption})
+				} else if !args.DryRun {
+					file := outputPath(name)
+					err = os.MkdirAll(filepath.Dir(file), 0755)
+					if err == nil {
+						err = writeOutput(file, caption)
+					}
+				}
+				if err != nil {
+					log.Fatalf("Could not write caption: %v", err)
+				}
+			}
+		}()
+	}
+	err = walkArchive(args.Path, accept, entries)
+	wg.Wait()
+	stats.skipped(b)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args.Path, err)
+	}
+	return nil
+}
+
+// captionBytes captions the image data of an archive entry or object, the
+// name is used in logs and for the --names
+func captionBytes(ol *hostPool, args args, name string, data []byte) (string, error) {
+	args.people = names.match(name, "")
+	if isRawFile(name) {
+		preview, err := rawPreview(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errCorruptImage, err)
+		}
+		data = preview
+	}
+	prompt, images, err := prepareImage(args, name, data)
+	if err != nil {
+		return "", err
+	}
+	var usage tokenUsage
+	logVerbose("Captioning %s (%d bytes) with %s", logPath(name), len(images[0]), args.Model)
+	return generateCaption(ol, args, &usage, name, prompt, images...)
+}
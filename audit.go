@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const altTextPrompt = "Write an alt text for this image that helps visually impaired users understand it. Answer only with one short sentence and do not start with \"Image of\" or \"Picture of\"."
+
+// altIssue is an image on a page that has no usable alt text
+type altIssue struct {
+	Page   string
+	Image  string
+	Status string // "missing" or "empty"
+}
+
+// sitemap covers both a sitemap url set and a sitemap index
+type sitemap struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// AuditSite finds images without alt text on the pages of a website export
+// (or the pages listed in a sitemap) and writes a CSV report with suggested alt texts
+func AuditSite(ol *hostPool, args args) error {
+	pages, err := sitePages(args.Path)
+	if err != nil {
+		return err
+	}
+
+	prompt := altPrompt(args)
+
+	out, err := os.Create(args.Audit)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := csv.NewWriter(out)
+	err = w.Write([]string{"page", "image", "alt_status", "suggested_alt"})
+	if err != nil {
+		return err
+	}
+
+	// images are often used on many pages, so we caption each only once
+	captions := map[string]string{}
+	for _, page := range pages {
+		issues, err := pageIssues(args.Path, page)
+		if err != nil {
+			logInfo("Skipping page %s: %v", page, err)
+			continue
+		}
+		for _, issue := range issues {
+			captionText, ok := captions[issue.Image]
+			if !ok {
+				imgData, err := loadSiteResource(args.Path, issue.Image)
+				if err == nil {
+					imgData, err = Preprocess(imgData, args.steps)
+				}
+				if err != nil {
+					logInfo("Skipping image %s: %v", issue.Image, err)
+					continue
+				}
+				captionText, err = suggestAlt(ol, args, prompt, imgData)
+				if err != nil {
+					return err
+				}
+				captions[issue.Image] = captionText
+			}
+			printResult(args, result{Page: issue.Page, Path: issue.Image, Caption: captionText}, "")
+			err = w.Write([]string{issue.Page, issue.Image, issue.Status, captionText})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// altPrompt is the prompt for alt texts unless --prompt asks for something else
+func altPrompt(args args) string {
+	prompt := args.Prompt
+	if prompt == defaultPrompt {
+		prompt = altTextPrompt
+	}
+	return prompt + localeHint(args)
+}
+
+// suggestAlt captions the (preprocessed) image with the alt text prompt
+func suggestAlt(ol *hostPool, args args, prompt string, imgData []byte) (string, error) {
+	captionText, err := CaptionImage(ol, args, nil, prompt, "", imgData)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(localize(args, captionText)), nil
+}
+
+// isURL checks if the site is given as http(s) URL
+func isURL(site string) bool {
+	return strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://")
+}
+
+// sitePages returns the page URLs of a sitemap or the slash separated paths
+// of all HTML files below the export directory
+func sitePages(site string) ([]string, error) {
+	if isURL(site) {
+		return sitemapPages(site, 0)
+	}
+
+	var pages []string
+	err := filepath.Walk(site, func(currentPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Continue walking despite errors
+		}
+		if info.IsDir() {
+			if currentPath != site && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(currentPath))
+		if ext == ".html" || ext == ".htm" {
+			rel, err := filepath.Rel(site, currentPath)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return pages, err
+}
+
+// sitemapPages fetches a sitemap and follows sitemap indexes (not too deep)
+func sitemapPages(sitemapURL string, depth int) ([]string, error) {
+	body, err := fetchURL(sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	var sm sitemap
+	err = xml.Unmarshal(body, &sm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sitemap %s: %w", sitemapURL, err)
+	}
+	pages := sm.URLs
+	if depth < 3 {
+		for _, sub := range sm.Sitemaps {
+			subPages, err := sitemapPages(strings.TrimSpace(sub), depth+1)
+			if err != nil {
+				logInfo("Skipping sitemap %s: %v", sub, err)
+				continue
+			}
+			pages = append(pages, subPages...)
+		}
+	}
+	for i := range pages {
+		pages[i] = strings.TrimSpace(pages[i])
+	}
+	return pages, nil
+}
+
+// pageIssues parses a page and returns its images without alt text
+func pageIssues(site string, page string) ([]altIssue, error) {
+	body, err := loadSiteResource(site, page)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := imagesWithoutAlt(body)
+	var issues []altIssue
+	for _, ref := range refs {
+		image, err := resolveSiteImage(page, ref.src)
+		if err != nil {
+			logInfo("Skipping image %q on %s: %v", ref.src, page, err)
+			continue
+		}
+		issues = append(issues, altIssue{Page: page, Image: image, Status: ref.status})
+	}
+	return issues, err
+}
+
+// altRef is the src of an img tag without alt text
+type altRef struct {
+	src    string
+	status string // "missing" or "empty"
+}
+
+// imagesWithoutAlt returns the img tags of the HTML that have no usable alt text
+func imagesWithoutAlt(body []byte) ([]altRef, error) {
+	var refs []altRef
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				return refs, nil
+			}
+			return refs, z.Err()
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tok := z.Token()
+		if tok.Data != "img" {
+			continue
+		}
+		var src string
+		hasAlt := false
+		altText := ""
+		for _, attr := range tok.Attr {
+			switch attr.Key {
+			case "src":
+				src = strings.TrimSpace(attr.Val)
+			case "alt":
+				hasAlt = true
+				altText = strings.TrimSpace(attr.Val)
+			}
+		}
+		if src == "" || strings.HasPrefix(src, "data:") || altText != "" {
+			continue
+		}
+		status := "missing"
+		if hasAlt {
+			status = "empty"
+		}
+		refs = append(refs, altRef{src: src, status: status})
+	}
+}
+
+// resolveSiteImage resolves the src of an image relative to the page it is used on.
+// Local pages stay slash separated paths relative to the export root unless
+// the image is referenced with an absolute URL.
+func resolveSiteImage(page string, src string) (string, error) {
+	ref, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	ref.Fragment = ""
+	if isURL(page) {
+		base, err := url.Parse(page)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if ref.Scheme != "" || ref.Host != "" {
+		if ref.Scheme == "" {
+			ref.Scheme = "https"
+		}
+		return ref.String(), nil
+	}
+	if strings.HasPrefix(ref.Path, "/") {
+		return strings.TrimPrefix(path.Clean(ref.Path), "/"), nil
+	}
+	return path.Join(path.Dir(page), ref.Path), nil
+}
+
+// loadSiteResource reads a page or image either from the web or from the export directory
+func loadSiteResource(site string, resource string) ([]byte, error) {
+	if isURL(resource) {
+		return fetchURL(resource)
+	}
+	return os.ReadFile(filepath.Join(site, filepath.FromSlash(resource)))
+}
+
+func fetchURL(resource string) ([]byte, error) {
+	resp, err := http.Get(resource)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", resource, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// captionBackups keeps the text of the caption files before they are
+// overwritten (--backup). Next to the caption only the first backup is kept, like
+// with --fill-alt, so another bad run can't replace the curated caption.
+// --backup-dir has a folder for every run instead.
+type captionBackups struct {
+	dir string // DIR/YYYYMMDD-HHMMSS, "" for FILE.bak
+}
+
+var backups *captionBackups // nil without --backup
+
+func newCaptionBackups(dir string) *captionBackups {
+	if dir == "" {
+		return &captionBackups{}
+	}
+	return &captionBackups{dir: filepath.Join(dir, time.Now().Format("20060102-150405"))}
+}
+
+// save copies the file if the text would change it
+func (b *captionBackups) save(file string, text string) error {
+	if b == nil {
+		return nil
+	}
+	old, err := os.ReadFile(file)
+	if os.IsNotExist(err) || err == nil && string(old) == text {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	backup := file + backupSuffix
+	if b.dir == "" && fileExists(backup) {
+		return nil
+	}
+	if b.dir != "" {
+		// the backups dir has the absolute paths of the captions
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		backup = filepath.Join(b.dir, strings.TrimPrefix(abs, filepath.VolumeName(abs)))
+		err = os.MkdirAll(filepath.Dir(backup), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	logVerbose("Backing up %s to %s", logPath(file), backup)
+	return os.WriteFile(backup, old, 0644)
+}
+
+// writeOutput writes a caption or an answer file, after the backup of the text it replaces
+func writeOutput(file string, text string) error {
+	err := backups.save(file, text)
+	if err != nil {
+		return fmt.Errorf("could not back up %s: %w", file, err)
+	}
+	return os.WriteFile(file, []byte(text), 0644)
+}
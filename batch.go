@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const openAIKeyEnv = "OPENAI_API_KEY"
+
+// the limits of the Batch API for one input file (with some headroom for the size)
+const (
+	maxBatchRequests = 50000
+	maxBatchBytes    = 190 << 20
+)
+
+// batchJob is one submitted batch, a big run is split into multiple batches
+type batchJob struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Requests  int    `json:"requests"`
+	Collected bool   `json:"collected"`
+}
+
+// batchRecord is the file of --batch, it remembers the submitted batches so
+// a run can be resumed while they are processed
+type batchRecord struct {
+	file string
+	Root string      `json:"root"`
+	Jobs []*batchJob `json:"jobs"`
+}
+
+func loadBatchRecord(file string) (*batchRecord, error) {
+	r := &batchRecord{file: file}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch file %s: %w", file, err)
+	}
+	return r, nil
+}
+
+func (r *batchRecord) save() error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.file + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.file)
+}
+
+func (r *batchRecord) pending() bool {
+	for _, job := range r.Jobs {
+		if !job.Collected {
+			return true
+		}
+	}
+	return false
+}
+
+// batchClient uses the files and batches endpoints of the OpenAI API
+type batchClient struct {
+	base string
+	key  string
+	http *http.Client
+}
+
+type openAIBatch struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	OutputFileID  string `json:"output_file_id"`
+	ErrorFileID   string `json:"error_file_id"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+// batchLine is a line of the batch input and output files
+type batchLine struct {
+	CustomID string        `json:"custom_id"`
+	Method   string        `json:"method,omitempty"`
+	URL      string        `json:"url,omitempty"`
+	Body     openAIRequest `json:"body"`
+}
+
+type batchResult struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int            `json:"status_code"`
+		Body       openAIResponse `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *batchClient) do(method string, path string, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		raw, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// upload streams the input file to the files endpoint
+func (c *batchClient) upload(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := mw.WriteField("purpose", "batch")
+		if err == nil {
+			var part io.Writer
+			part, err = mw.CreateFormFile("file", "capollama-batch.jsonl")
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var uploaded struct {
+		ID string `json:"id"`
+	}
+	err = c.do(http.MethodPost, "/files", mw.FormDataContentType(), pr, &uploaded)
+	return uploaded.ID, err
+}
+
+func (c *batchClient) create(inputFileID string) (*openAIBatch, error) {
+	body, err := json.Marshal(map[string]string{
+		"input_file_id":     inputFileID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var batch openAIBatch
+	err = c.do(http.MethodPost, "/batches", "application/json", bytes.NewReader(body), &batch)
+	return &batch, err
+}
+
+func (c *batchClient) get(id string) (*openAIBatch, error) {
+	var batch openAIBatch
+	err := c.do(http.MethodGet, "/batches/"+id, "", nil, &batch)
+	return &batch, err
+}
+
+func (c *batchClient) download(fileID string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := c.do(http.MethodGet, "/files/"+fileID+"/content", "", nil, &buf)
+	return buf.Bytes(), err
+}
+
+// runBatch captions the images with the OpenAI Batch API. New batches are
+// only submitted when all batches of the record are collected, so running
+// it again with the same --batch file resumes waiting for the results.
+func runBatch(args args, state *runState, imported *skipList) error {
+	key := os.Getenv(openAIKeyEnv)
+	if key == "" {
+		return fmt.Errorf("the API key must be set in %s", openAIKeyEnv)
+	}
+	c := &batchClient{base: strings.TrimRight(args.OpenAIURL, "/"), key: key, http: backendClient}
+
+	record, err := loadBatchRecord(args.Batch)
+	if err != nil {
+		return err
+	}
+	if record.pending() {
+		logInfo("Resuming %d batches from %s", len(record.Jobs), args.Batch)
+	} else {
+		todo, root, _, err := collectTodo(args, state, imported)
+		if err != nil {
+			return err
+		}
+		if len(todo) == 0 {
+			logInfo("No images to caption")
+			return nil
+		}
+		record.Root = root
+		record.Jobs = nil
+		err = submitBatches(c, args, record, todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	for record.pending() {
+		for _, job := range record.Jobs {
+			if job.Collected {
+				continue
+			}
+			batch, err := c.get(job.ID)
+			if err != nil {
+				logError("Could not check batch %s: %v", job.ID, err)
+				continue
+			}
+			if batch.Status != job.Status {
+				logInfo("Batch %s is %s (%d of %d done, %d failed)", job.ID, batch.Status,
+					batch.RequestCounts.Completed, batch.RequestCounts.Total, batch.RequestCounts.Failed)
+				job.Status = batch.Status
+			}
+			switch batch.Status {
+			case "completed", "failed", "expired", "cancelled":
+				// expired and cancelled batches may have partial results
+				for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+					if fileID == "" {
+						continue
+					}
+					err = collectBatch(c, args, state, record.Root, fileID)
+					if err != nil {
+						return err
+					}
+				}
+				job.Collected = true
+			}
+			err = record.save()
+			if err != nil {
+				return err
+			}
+		}
+		if record.pending() {
+			time.Sleep(args.BatchPoll)
+		}
+	}
+	return nil
+}
+
+// submitBatches writes the input files (split at the limits of the Batch API),
+// uploads them and creates the batches
+func submitBatches(c *batchClient, args args, record *batchRecord, todo []string) error {
+	input, err := os.CreateTemp("", "capollama-batch-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(input.Name())
+	defer input.Close()
+
+	requests := 0
+	size := 0
+	submit := func() error {
+		if requests == 0 {
+			return nil
+		}
+		id, err := c.upload(input.Name())
+		if err != nil {
+			return err
+		}
+		batch, err := c.create(id)
+		if err != nil {
+			return err
+		}
+		logInfo("Submitted batch %s with %d images", batch.ID, requests)
+		record.Jobs = append(record.Jobs, &batchJob{ID: batch.ID, Status: batch.Status, Requests: requests})
+		err = record.save()
+		if err != nil {
+			return err
+		}
+		requests, size = 0, 0
+		_, err = input.Seek(0, io.SeekStart)
+		if err == nil {
+			err = input.Truncate(0)
+		}
+		return err
+	}
+
+	for _, path := range todo {
+		imageArgs, err := overrides.apply(args, path, record.Root)
+		if err != nil {
+			logError("Skipping %s: %v", logPath(path), err)
+			continue
+		}
+		// all requests of a batch must use the same model
+		imageArgs.Model = args.Model
+		system := imageArgs.System
+		if args.UseChatAPI {
+			system = ""
+		}
+		prompt, images, err := loadImage(imageArgs, path)
+		if errors.Is(err, errCorruptImage) {
+			err = skipCorrupt(args, path, record.Root, err, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			logError("Skipping %s: %v", logPath(path), err)
+			continue
+		}
+		line, err := json.Marshal(batchLine{
+			CustomID: path,
+			Method:   http.MethodPost,
+			URL:      "/v1/chat/completions",
+			Body:     openAIBody(args.Model, promptMessages(system, prompt, imageData(images)), options(args), ""),
+		})
+		if err != nil {
+			return err
+		}
+		if requests >= maxBatchRequests || size+len(line)+1 > maxBatchBytes {
+			err = submit()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = input.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+		requests++
+		size += len(line) + 1
+	}
+	return submit()
+}
+
+// collectBatch writes the captions of an output (or error) file of a batch
+func collectBatch(c *batchClient, args args, state *runState, root string, fileID string) error {
+	data, err := c.download(fileID)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var res batchResult
+		err := json.Unmarshal(scanner.Bytes(), &res)
+		if err != nil {
+			return fmt.Errorf("invalid batch result: %w", err)
+		}
+		path := res.CustomID
+
+		err = batchResultError(res)
+		if err == nil {
+			body := res.Response.Body
+			used := tokenUsage{Prompt: body.Usage.PromptTokens, Completion: body.Usage.CompletionTokens}
+			stats.addTokens(args.Model, used)
+			imageArgs := args
+			imageArgs, err = overrides.apply(imageArgs, path, root)
+			if err == nil {
+				captionText := finishCaption(imageArgs, path, body.Choices[0].Message.Content)
+				err = saveResult(args, path, root, captionFile(path), imageMetadata{Caption: captionText})
+				report.add(imageArgs, path, root, captionText, nil)
+				notifyImage(args, result{Path: path, Caption: captionText}, nil, 0)
+			}
+		}
+		stats.imageDone(path, 0, err)
+		var saveErr error
+		if err != nil {
+			logError("Failed %s: %v", logPath(path), err)
+			if state != nil {
+				_, saveErr = state.failed(path, err, args.MaxAttempts)
+			}
+		} else if state != nil {
+			saveErr = state.succeeded(path)
+		}
+		if saveErr != nil {
+			return fmt.Errorf("could not write state: %w", saveErr)
+		}
+	}
+	return scanner.Err()
+}
+
+func batchResultError(res batchResult) error {
+	switch {
+	case res.Error != nil:
+		return fmt.Errorf("%s: %s", res.Error.Code, res.Error.Message)
+	case res.Response == nil:
+		return fmt.Errorf("no response")
+	case res.Response.StatusCode >= http.StatusBadRequest:
+		if res.Response.Body.Error != nil {
+			return fmt.Errorf("status %d: %s", res.Response.StatusCode, res.Response.Body.Error.Message)
+		}
+		return fmt.Errorf("status %d", res.Response.StatusCode)
+	case len(res.Response.Body.Choices) == 0:
+		return fmt.Errorf("no choices in the answer")
+	}
+	return nil
+}
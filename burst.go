@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// frame is an image of a folder with the time it was taken
+type frame struct {
+	index int // in the todo list
+	taken time.Time
+}
+
+// detectBursts finds the sequences of frames of --bursts: in a folder the
+// images in the order they were taken, each one at most --bursts after the
+// previous one and similar to the first one of the sequence. The middle frame
+// of a burst is captioned, the others are returned with it and get its caption.
+func detectBursts(args args, todo []string, root string) ([]string, map[string]string) {
+	folders := map[string][]frame{}
+	for i, path := range todo {
+		taken, ok := exifDateTaken(path)
+		if !ok {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			taken = info.ModTime()
+		}
+		dir := filepath.Dir(path)
+		folders[dir] = append(folders[dir], frame{index: i, taken: taken})
+	}
+	hashes, failed := hashImages(todo)
+
+	copies := map[string]string{}
+	addBurst := func(burst []frame) {
+		if len(burst) < 2 {
+			return
+		}
+		middle := todo[burst[len(burst)/2].index]
+		for _, f := range burst {
+			if todo[f.index] != middle {
+				copies[todo[f.index]] = middle
+			}
+		}
+		logVerbose("Burst of %d frames from %s to %s, captioning %s", len(burst),
+			strings.TrimPrefix(todo[burst[0].index], root), strings.TrimPrefix(todo[burst[len(burst)-1].index], root), strings.TrimPrefix(middle, root))
+	}
+	for _, frames := range folders {
+		sort.SliceStable(frames, func(i, j int) bool {
+			if frames[i].taken.Equal(frames[j].taken) {
+				// the frames of a second are in the order of their numbers
+				return todo[frames[i].index] < todo[frames[j].index]
+			}
+			return frames[i].taken.Before(frames[j].taken)
+		})
+		var burst []frame
+		for _, f := range frames {
+			if failed[f.index] {
+				// the image is captioned on its own and fails there if it is broken
+				addBurst(burst)
+				burst = nil
+				continue
+			}
+			if len(burst) > 0 {
+				first, last := burst[0], burst[len(burst)-1]
+				if f.taken.Sub(last.taken) > args.Bursts || bits.OnesCount64(hashes[f.index]^hashes[first.index]) > args.BurstDistance {
+					addBurst(burst)
+					burst = nil
+				}
+			}
+			burst = append(burst, f)
+		}
+		addBurst(burst)
+	}
+
+	var keep []string
+	for _, path := range todo {
+		if copies[path] == "" {
+			keep = append(keep, path)
+		}
+	}
+	return keep, copies
+}
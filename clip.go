@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxClipMerges is the number of merges the CLIP tokenizer uses from its vocabulary
+const maxClipMerges = 49152 - 256 - 2
+
+// clipSplitRE splits the text into words like the CLIP tokenizer before the BPE
+var clipSplitRE = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d|\p{L}+|\p{N}|[^\s\p{L}\p{N}]+`)
+
+// clipTokenizer counts the BPE tokens of the CLIP text encoder of Stable Diffusion
+type clipTokenizer struct {
+	ranks   map[[2]string]int // nil without --clip-vocab, then the tokens are estimated
+	byteMap [256]rune
+}
+
+var clip *clipTokenizer
+
+// newClipTokenizer loads the merges of the CLIP vocabulary (bpe_simple_vocab_16e6.txt.gz
+// of OpenAI or the merges.txt of the Hugging Face tokenizer), gzipped or not
+func newClipTokenizer(vocab string) (*clipTokenizer, error) {
+	t := &clipTokenizer{}
+	// the byte to unicode table of GPT-2 and CLIP, the printable bytes keep their rune
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			t.byteMap[b] = rune(b)
+		} else {
+			t.byteMap[b] = rune(256 + n)
+			n++
+		}
+	}
+	if vocab == "" {
+		return t, nil
+	}
+
+	f, err := os.Open(vocab)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(vocab, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLIP vocabulary %s: %w", vocab, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	t.ranks = map[[2]string]int{}
+	scanner := bufio.NewScanner(r)
+	// the first line is the version
+	scanner.Scan()
+	for scanner.Scan() && len(t.ranks) < maxClipMerges {
+		a, b, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		t.ranks[[2]string{a, b}] = len(t.ranks)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read CLIP vocabulary %s: %w", vocab, err)
+	}
+	if len(t.ranks) == 0 {
+		return nil, fmt.Errorf("no merges in CLIP vocabulary %s", vocab)
+	}
+	logVerbose("Loaded %d merges from CLIP vocabulary %s", len(t.ranks), vocab)
+	return t, nil
+}
+
+// count returns the number of tokens without the start and end tokens
+func (t *clipTokenizer) count(text string) int {
+	tokens := 0
+	for _, word := range clipSplitRE.FindAllString(strings.ToLower(strings.Join(strings.Fields(text), " ")), -1) {
+		if t.ranks == nil {
+			tokens += estimateClipTokens(word)
+			continue
+		}
+		tokens += len(t.bpe(word))
+	}
+	return tokens
+}
+
+// estimateClipTokens errs on the long side: most common English words are a
+// single token, longer and rarer words are split into pieces
+func estimateClipTokens(word string) int {
+	r, _ := utf8.DecodeRuneInString(word)
+	if !isWordRune(r) {
+		return utf8.RuneCountInString(word)
+	}
+	return (utf8.RuneCountInString(word) + 5) / 6
+}
+
+// bpe merges the symbols of the word in the order of the vocabulary
+func (t *clipTokenizer) bpe(word string) []string {
+	var symbols []string
+	for _, b := range []byte(word) {
+		symbols = append(symbols, string(t.byteMap[b]))
+	}
+	symbols[len(symbols)-1] += "</w>"
+	for len(symbols) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(symbols)-1; i++ {
+			rank, ok := t.ranks[[2]string{symbols[i], symbols[i+1]}]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		// merge every occurrence of the pair
+		pair := [2]string{symbols[best], symbols[best+1]}
+		var merged []string
+		for i := 0; i < len(symbols); i++ {
+			if i < len(symbols)-1 && symbols[i] == pair[0] && symbols[i+1] == pair[1] {
+				merged = append(merged, pair[0]+pair[1])
+				i++
+				continue
+			}
+			merged = append(merged, symbols[i])
+		}
+		symbols = merged
+	}
+	return symbols
+}
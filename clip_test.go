@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testMerges is a small vocabulary in the format of bpe_simple_vocab_16e6.txt
+const testMerges = "#version: 0.2\nc a\nca t</w>\nd o\ndo g</w>\no g\nh o\nho t</w>\ns </w>\na n\nan a\nana n\nanan a</w>\nÃ ©</w>\nc a</w>\n"
+
+func writeTestVocab(t *testing.T, name string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".gz" {
+		gz := gzip.NewWriter(f)
+		_, err = gz.Write([]byte(testMerges))
+		if err == nil {
+			err = gz.Close()
+		}
+	} else {
+		_, err = f.Write([]byte(testMerges))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// the expectations are those of simple_tokenizer.py of CLIP with the same merges
+func TestClipBPE(t *testing.T) {
+	tok, err := newClipTokenizer(writeTestVocab(t, "merges.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"cat", []string{"cat</w>"}},
+		{"dog", []string{"dog</w>"}},
+		{"ca", []string{"ca</w>"}},
+		{"hogs", []string{"h", "og", "s</w>"}},
+		{"banana", []string{"b", "an", "an", "a</w>"}},
+		{"bananas", []string{"b", "an", "ana", "s</w>"}},
+		{"café", []string{"ca", "f", "Ã©</w>"}},
+		{"'s", []string{"'", "s</w>"}},
+		{"x", []string{"x</w>"}},
+	}
+	for _, tt := range tests {
+		got := tok.bpe(tt.word)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bpe(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestClipCount(t *testing.T) {
+	for _, name := range []string{"merges.txt", "bpe_simple_vocab.txt.gz"} {
+		tok, err := newClipTokenizer(writeTestVocab(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			text string
+			want int
+		}{
+			{"cat", 1},
+			{"a cat", 2},
+			{"Hot DOG's", 4},
+			{"  Cat,   dog ", 3},
+			// digits are single tokens, punctuation stays together before the BPE
+			{"1024 cats!!!", 10},
+			{"", 0},
+		}
+		for _, tt := range tests {
+			got := tok.count(tt.text)
+			if got != tt.want {
+				t.Errorf("%s: count(%q) = %d, want %d", name, tt.text, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestClipEstimate(t *testing.T) {
+	tok, err := newClipTokenizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"a cat", 2},
+		{"photograph", 2},
+		{"a cat, 4k", 5},
+		{"wow!!!", 4},
+	}
+	for _, tt := range tests {
+		got := tok.count(tt.text)
+		if got != tt.want {
+			t.Errorf("count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestClipVocabErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	err := os.WriteFile(empty, []byte("#version: 0.2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newClipTokenizer(empty)
+	if err == nil {
+		t.Error("no error for a vocabulary without merges")
+	}
+	_, err = newClipTokenizer(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Error("no error for a missing vocabulary")
+	}
+}
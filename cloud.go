@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// metadataStore keeps the captions in the metadata of the files instead of
+// caption files, like the comments and tags of Nextcloud
+type metadataStore interface {
+	hasCaption(args args, key string) bool
+	writeCaption(args args, key string, caption string) error
+}
+
+// captionStore captions the images below the prefix of the object storage URL
+// of PATH. The captions are uploaded as sibling objects (photos/a.jpg gets
+// photos/a.txt), written to --manifest or with --nextcloud to the metadata.
+func captionStore(ol *hostPool, args args) error {
+	store, prefix, err := openStore(args.Path)
+	if err != nil {
+		return err
+	}
+	var meta metadataStore
+	if args.Nextcloud {
+		meta = store.(metadataStore)
+	}
+	keys, err := store.list(prefix)
+	if err != nil {
+		return err
+	}
+	var m *manifest
+	if args.Manifest != "" {
+		m, err = openManifest(args.Manifest, args.DryRun)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+	}
+
+	// the listing already tells which images have a caption
+	existing := map[string]bool{}
+	for _, key := range keys {
+		existing[key] = true
+	}
+	var b backlog
+	var todo []string
+	for _, key := range keys {
+		if !isImageFile(key) {
+			continue
+		}
+		b.Images++
+		var has bool
+		switch {
+		case meta != nil:
+			has = meta.hasCaption(args, key)
+		case m != nil:
+			has = m.has(store.url(key))
+		default:
+			has = existing[captionFile(key)]
+		}
+		if !args.Force && has {
+			b.Existing++
+			continue
+		}
+		todo = append(todo, key)
+	}
+	stats.skipped(b)
+	logVerbose("Found %d images in %s, %d without caption", b.Images, args.Path, len(todo))
+
+	var prog *progress
+	if args.Progress {
+		prog = startProgress(len(todo))
+	}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				u := store.url(key)
+				start := time.Now()
+				data, err := store.get(key)
+				var caption string
+				if err == nil {
+					caption, err = captionBytes(ol, args, u, data)
+				}
+				stats.imageDone(u, time.Since(start), err)
+				prog.step()
+				if errors.Is(err, errCorruptImage) {
+					logError("Skipping %s: %v", logPath(u), err)
+					stats.corrupted(u)
+					continue
+				}
+				if err != nil {
+					logError("Failed %s: %v", logPath(u), err)
+					continue
+				}
+				printResult(args, result{Path: u, Caption: caption}, "")
+				notifyImage(args, result{Path: u, Caption: caption}, nil, time.Since(start))
+				if m != nil {
+					err = m.add(manifestEntry{URL: u, Caption: caption})
+				} else if args.DryRun {
+					continue
+				} else if meta != nil {
+					err = meta.writeCaption(args, key, caption)
+				} else {
+					err = store.put(captionFile(key), []byte(caption))
+				}
+				if err != nil {
+					log.Fatalf("Could not write caption: %v", err)
+				}
+			}
+		}()
+	}
+	for _, key := range todo {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+	prog.finish()
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+)
+
+// command is a subcommand like "capollama watch", each has its own flags
+type command struct {
+	name string
+	help string
+	run  func(cmdline []string)
+}
+
+var commands = []command{
+	{"caption", "Caption images (the default, capollama PATH is the same as capollama caption PATH)", func(cmdline []string) {
+		runCaption(parseCaption(appName+" caption", cmdline))
+	}},
+	{"watch", "Keep running and caption the new images of a growing folder", func(cmdline []string) {
+		runCaption(parseWatch(cmdline))
+	}},
+	{"daemon", "Keep running and caption the folders and images of a persistent queue by priority", func(cmdline []string) {
+		runCaption(parseDaemon(cmdline))
+	}},
+	{"enqueue", "Add folders and images to the queue of the daemon", runEnqueue},
+	{"queue", "List the jobs of the daemon queue", runQueue},
+	{"status", "Show the daemon, its running job and the counts of the queue", runStatus},
+	{"review", "Step through the captioned images to accept, edit or regenerate their captions", func(cmdline []string) {
+		runCaption(parseReview(cmdline))
+	}},
+	{"vqa", "Ask the questions of a CSV file about its images and write the answers", func(cmdline []string) {
+		runCaption(parseVQA(cmdline))
+	}},
+	{"models", "List the models of the backend and show which support images and which are loaded", runModels},
+	{"serve", "Serve the images and captions over HTTP, with --ui as web app for browsing and editing", func(cmdline []string) {
+		runCaption(parseServe(cmdline))
+	}},
+	{"export", "Export the captions of a directory for training tools (kohya, hf, llava)", runExport},
+	{"rerun", "Run again with the configuration recorded in a run manifest", func(cmdline []string) {
+		runCaption(parseRerun(cmdline))
+	}},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// commandList is shown at the end of the help of the caption command
+func commandList() string {
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "  %-22s %s\n", cmd.name, cmd.help)
+	}
+	b.WriteString("\nUse \"" + appName + " COMMAND --help\" for the flags of a command.")
+	return b.String()
+}
+
+func (args) Epilogue() string {
+	return commandList()
+}
+
+func newParser(program string, dest any) *arg.Parser {
+	// usage errors exit with exitConfig, --help and --version with 0
+	exit := func(code int) {
+		if code != 0 {
+			code = exitConfig
+		}
+		os.Exit(code)
+	}
+	p, err := arg.NewParser(arg.Config{Program: program, Exit: exit}, dest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return p
+}
+
+// parseCaption parses the flags of the caption command
+func parseCaption(program string, cmdline []string) (*arg.Parser, args) {
+	a := args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}
+	p := newParser(program, &a)
+	p.MustParse(cmdline)
+	return p, a
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+)
+
+// modelSuffix is the suffix of the caption files of a model when several
+// models are compared, like .qwen2.5vl_7b.txt
+func modelSuffix(model string) string {
+	return "." + strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(model) + ".txt"
+}
+
+// processModels captions the image with every --model, with only one model
+// the caption is written to the .txt
+func processModels(ol *hostPool, args args, path string, root string) error {
+	if len(args.Models) == 1 {
+		return processImage(ol, args, path, root, captionFile(path))
+	}
+	for _, model := range args.Models {
+		file := outputFile(path, modelSuffix(model))
+		modelArgs := args
+		modelArgs.Model = model
+		modelArgs.keepModel = true
+		if !args.Force && fileExists(file) {
+			// the existing caption is still part of the comparison
+			caption, _ := readCaption(file)
+			report.add(modelArgs, path, root, caption, nil)
+			continue
+		}
+		err := processImage(ol, modelArgs, path, root, file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modelCaption is the caption of one of the compared models
+type modelCaption struct {
+	Model   string
+	Caption string
+}
+
+// compareEntries merges the entries of the models into one entry per image
+// with the captions side by side, the entries are sorted by path
+func compareEntries(entries []reportEntry, model string) []reportEntry {
+	var merged []reportEntry
+	for _, entry := range entries {
+		if len(merged) == 0 || merged[len(merged)-1].file != entry.file {
+			merged = append(merged, reportEntry{Path: entry.Path, Model: model, Prompt: entry.Prompt, file: entry.file})
+		}
+		last := &merged[len(merged)-1]
+		last.Compared = append(last.Compared, modelCaption{Model: entry.Model, Caption: entry.Caption})
+	}
+	return merged
+}
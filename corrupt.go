@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// errCorruptImage marks images that can't be decoded, they are skipped
+// instead of being sent to the model
+var errCorruptImage = errors.New("corrupt image")
+
+// validateImage decodes the whole image, so truncated files are found too
+func validateImage(imgData []byte) error {
+	if len(imgData) == 0 {
+		return fmt.Errorf("%w: empty file", errCorruptImage)
+	}
+	_, err := transcoding.run(imgData, func(buf *bytes.Buffer) error {
+		_, _, err := image.Decode(bytes.NewReader(imgData))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCorruptImage, err)
+	}
+	return nil
+}
+
+// skipCorrupt records a corrupt image, it is poisoned right away in the state
+// because another attempt can't help, and moves it to --quarantine
+func skipCorrupt(args args, path string, root string, cause error, state *runState) error {
+	logError("Skipping %s: %v", logPath(path), cause)
+	stats.corrupted(path)
+	if state != nil {
+		_, err := state.failed(path, cause, 1)
+		if err != nil {
+			return err
+		}
+	}
+	if args.Quarantine != "" && !args.DryRun {
+		dest, err := moveImage(args, path, root, args.Quarantine)
+		if err != nil {
+			return err
+		}
+		logInfo("Moved %s to %s", logPath(path), dest)
+	}
+	return nil
+}
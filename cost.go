@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tokenUsage counts the tokens of one or more requests
+type tokenUsage struct {
+	Prompt     int `json:"prompt"`
+	Completion int `json:"completion"`
+}
+
+func (u *tokenUsage) add(o tokenUsage) {
+	if u == nil {
+		return
+	}
+	u.Prompt += o.Prompt
+	u.Completion += o.Completion
+}
+
+// modelPrice is the price per million tokens
+type modelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// priceTable maps model names to their prices, "*" is used for all other models
+type priceTable map[string]modelPrice
+
+// prices is loaded from --prices, without it no costs are shown
+var prices priceTable
+
+func loadPrices(file string) (priceTable, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var table priceTable
+	err = json.Unmarshal(data, &table)
+	if err != nil {
+		return nil, fmt.Errorf("invalid price table %s: %w", file, err)
+	}
+	return table, nil
+}
+
+// cost calculates the price of the usage for the model and reports if the model has a price
+func (pt priceTable) cost(model string, u tokenUsage) (float64, bool) {
+	price, ok := pt[model]
+	if !ok {
+		price, ok = pt[strings.TrimSuffix(model, ":latest")]
+	}
+	if !ok {
+		price, ok = pt["*"]
+	}
+	if !ok {
+		return 0, false
+	}
+	return (float64(u.Prompt)*price.Prompt + float64(u.Completion)*price.Completion) / 1e6, true
+}
+
+func formatCost(cost float64) string {
+	return fmt.Sprintf("$%.4f", cost)
+}
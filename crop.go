@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const detailCropHint = "\nThe second image is an enlarged detail of the first image. Use it to recognize small details, but describe the first image."
+
+// the saliency map is calculated on a downscaled copy for speed
+const saliencySize = 256
+
+// DetailCrop finds the most salient region of the image and returns it as
+// JPEG. The fraction is the size of the crop relative to the image sides.
+func DetailCrop(imgData []byte, fraction float64) ([]byte, error) {
+	return transcoding.run(imgData, func(buf *bytes.Buffer) error {
+		return detailCrop(buf, imgData, fraction)
+	})
+}
+
+func detailCrop(buf *bytes.Buffer, imgData []byte, fraction float64) error {
+	src, _, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	img := toNRGBA(src)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	small := resizeToFit(img, saliencySize)
+	sw, sh := small.Rect.Dx(), small.Rect.Dy()
+	cw, ch := max(int(float64(sw)*fraction), 1), max(int(float64(sh)*fraction), 1)
+	x, y := mostSalientWindow(small, cw, ch)
+
+	// map the window back to the full size image
+	scaleX, scaleY := float64(w)/float64(sw), float64(h)/float64(sh)
+	crop := image.Rect(
+		int(float64(x)*scaleX), int(float64(y)*scaleY),
+		min(int(float64(x+cw)*scaleX), w), min(int(float64(y+ch)*scaleY), h),
+	)
+	detail := toNRGBA(img.SubImage(crop))
+
+	// upscale small crops, so the model gets more pixels of the details
+	if detail.Rect.Dx() < saliencySize*2 && detail.Rect.Dy() < saliencySize*2 {
+		factor := float64(saliencySize*2) / float64(max(detail.Rect.Dx(), detail.Rect.Dy()))
+		dst := image.NewNRGBA(image.Rect(0, 0, int(float64(detail.Rect.Dx())*factor), int(float64(detail.Rect.Dy())*factor)))
+		draw.CatmullRom.Scale(dst, dst.Rect, detail, detail.Rect, draw.Src, nil)
+		detail = dst
+	}
+
+	return jpeg.Encode(buf, detail, &jpeg.Options{Quality: 90})
+}
+
+// mostSalientWindow returns the top left corner of the cw x ch window with
+// the highest saliency. Saliency is the local contrast (gradient magnitude)
+// plus the color distance to the average color of the image.
+func mostSalientWindow(img *image.NRGBA, cw, ch int) (int, int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	var avgR, avgG, avgB float64
+	for i := 0; i < len(img.Pix); i += 4 {
+		avgR += float64(img.Pix[i])
+		avgG += float64(img.Pix[i+1])
+		avgB += float64(img.Pix[i+2])
+	}
+	n := float64(w * h)
+	avgR, avgG, avgB = avgR/n, avgG/n, avgB/n
+
+	lum := func(x, y int) float64 {
+		i := img.PixOffset(min(max(x, 0), w-1), min(max(y, 0), h-1))
+		return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+	}
+
+	// integral image of the saliency so every window sum is O(1)
+	sum := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			dx := lum(x+1, y) - lum(x-1, y)
+			dy := lum(x, y+1) - lum(x, y-1)
+			dr, dg, db := float64(img.Pix[i])-avgR, float64(img.Pix[i+1])-avgG, float64(img.Pix[i+2])-avgB
+			row += math.Sqrt(dx*dx+dy*dy) + math.Sqrt(dr*dr+dg*dg+db*db)/2
+			sum[(y+1)*(w+1)+x+1] = sum[y*(w+1)+x+1] + row
+		}
+	}
+
+	bestX, bestY := (w-cw)/2, (h-ch)/2
+	best := -1.0
+	for y := 0; y+ch <= h; y++ {
+		for x := 0; x+cw <= w; x++ {
+			s := sum[(y+ch)*(w+1)+x+cw] - sum[y*(w+1)+x+cw] - sum[(y+ch)*(w+1)+x] + sum[y*(w+1)+x]
+			if s > best {
+				best, bestX, bestY = s, x, y
+			}
+		}
+	}
+	return bestX, bestY
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLimit is a --newer-than or --older-than, a fixed date or an age that is
+// counted back from now, so watch goes on with the newest images
+type dateLimit struct {
+	date time.Time
+	age  time.Duration
+}
+
+func (d dateLimit) isSet() bool {
+	return !d.date.IsZero() || d.age > 0
+}
+
+func (d dateLimit) time() time.Time {
+	if d.age > 0 {
+		return time.Now().Add(-d.age)
+	}
+	return d.date
+}
+
+// parseDateLimit accepts a date (2024-01-01, 2024-01-01T12:00:00 or RFC 3339)
+// or an age like 30d, 2w or 12h
+func parseDateLimit(s string) (dateLimit, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return dateLimit{date: t}, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return dateLimit{date: t}, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) && n > 0 {
+			return dateLimit{age: time.Duration(n) * unit}, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return dateLimit{age: d}, nil
+	}
+	return dateLimit{}, fmt.Errorf("invalid date %q, use a date like 2024-01-01 or an age like 30d, 2w or 12h", s)
+}
+
+// filterDates keeps the images between --newer-than and --older-than
+func filterDates(images []imageFile, opts walkOptions) []imageFile {
+	if !opts.NewerThan.isSet() && !opts.OlderThan.isSet() {
+		return images
+	}
+	newer, older := opts.NewerThan.time(), opts.OlderThan.time()
+	var kept []imageFile
+	for _, image := range images {
+		date := image.Info.ModTime()
+		if opts.DateFrom == "exif" {
+			if taken, ok := exifDateTaken(image.Path); ok {
+				date = taken
+			}
+		}
+		if opts.NewerThan.isSet() && !date.After(newer) || opts.OlderThan.isSet() && !date.Before(older) {
+			continue
+		}
+		kept = append(kept, image)
+	}
+	opts.filtered(len(images) - len(kept))
+	return kept
+}
+
+// the EXIF of a JPEG is at its start, the IFDs of a TIFF based RAW file usually are
+const exifReadSize = 256 * 1024
+
+// exifDateTaken reads DateTimeOriginal of a JPEG or a TIFF based RAW file, in
+// local time as EXIF has no time zone
+func exifDateTaken(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, exifReadSize))
+	if err != nil {
+		return time.Time{}, false
+	}
+	tiff := data
+	if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xD8 {
+		tiff = jpegExif(data)
+	}
+	value, ok := tiffDateTaken(tiff)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	return t, err == nil
+}
+
+// jpegExif is the TIFF data of the APP1 segment, like in exifOrientation
+func jpegExif(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos += 2 + size
+	}
+	return nil
+}
+
+// tiffDateTaken follows the Exif IFD pointer (0x8769) of IFD0 to DateTimeOriginal (0x9003)
+func tiffDateTaken(tiff []byte) (string, bool) {
+	if len(tiff) < 8 {
+		return "", false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return "", false
+	}
+	// findTag returns the value or offset field of the tag in the IFD
+	findTag := func(ifd int, tag uint16) ([]byte, bool) {
+		if ifd <= 0 || ifd+2 > len(tiff) {
+			return nil, false
+		}
+		count := int(order.Uint16(tiff[ifd:]))
+		for i := 0; i < count; i++ {
+			entry := ifd + 2 + i*12
+			if entry+12 > len(tiff) {
+				return nil, false
+			}
+			if order.Uint16(tiff[entry:]) == tag {
+				return tiff[entry : entry+12], true
+			}
+		}
+		return nil, false
+	}
+	pointer, ok := findTag(int(order.Uint32(tiff[4:])), 0x8769)
+	if !ok {
+		return "", false
+	}
+	entry, ok := findTag(int(order.Uint32(pointer[8:])), 0x9003)
+	if !ok {
+		return "", false
+	}
+	// an ASCII value of 20 bytes, "YYYY:MM:DD HH:MM:SS\x00", stored at the offset
+	size, offset := int(order.Uint32(entry[4:])), int(order.Uint32(entry[8:]))
+	if size < 19 || offset+19 > len(tiff) {
+		return "", false
+	}
+	return string(tiff[offset : offset+19]), true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// backendClient sends the requests to the backends, its transport has the
+// --http-* settings and is wrapped by --debug-dump
+var backendClient = http.DefaultClient
+
+// longer strings without spaces in the request and response are images
+// (base64 or data URLs) and elided in the dump
+const dumpMaxString = 256
+
+// the headers with credentials are not written to the dump
+var dumpSecretHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "Cookie"}
+
+var nonFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type imageKey struct{}
+
+// withImage tells the backend requests of the context which image they are
+// for, the dump files and the --stream output are named after it
+func withImage(ctx context.Context, image string) context.Context {
+	return context.WithValue(ctx, imageKey{}, image)
+}
+
+// contextImage is the image of withImage, "" if there is none (preflight)
+func contextImage(ctx context.Context) string {
+	image, _ := ctx.Value(imageKey{}).(string)
+	return image
+}
+
+// dumpTransport writes every request to the backend and its response as
+// NNNN-IMAGE.request.json and NNNN-IMAGE.response.json to the folder
+type dumpTransport struct {
+	dir  string
+	next http.RoundTripper
+	seq  atomic.Int64
+}
+
+func newDumpTransport(dir string, next http.RoundTripper) (*dumpTransport, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create --debug-dump folder: %w", err)
+	}
+	// the numbers go on after the dumps of earlier runs
+	earlier, _ := filepath.Glob(filepath.Join(dir, "*.request.json"))
+	t := &dumpTransport{dir: dir, next: next}
+	t.seq.Store(int64(len(earlier)))
+	return t, nil
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	image := contextImage(req.Context())
+	// the requests of the preflight are named after the endpoint (tags, show)
+	name := path.Base(req.URL.Path)
+	if image != "" {
+		name = nonFileName.ReplaceAllString(filepath.Base(image), "_")
+	}
+	base := filepath.Join(t.dir, fmt.Sprintf("%04d-%s", t.seq.Add(1), name))
+
+	// the body is read completely, a streamed upload is not streamed while dumping
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	t.write(base+".request.json", map[string]any{
+		"time":    time.Now(),
+		"image":   image,
+		"method":  req.Method,
+		"url":     req.URL.String(),
+		"headers": dumpHeaders(req.Header),
+		"body":    dumpBody(body),
+	})
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	dump := map[string]any{"time": time.Now(), "image": image, "duration_seconds": time.Since(start).Seconds()}
+	if err != nil {
+		dump["error"] = err.Error()
+		t.write(base+".response.json", dump)
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	dump["status"] = resp.Status
+	dump["headers"] = dumpHeaders(resp.Header)
+	dump["body"] = dumpBody(data)
+	if err != nil {
+		dump["error"] = err.Error()
+	}
+	t.write(base+".response.json", dump)
+	return resp, err
+}
+
+func (t *dumpTransport) write(file string, dump map[string]any) {
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err == nil {
+		err = os.WriteFile(file, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		logError("Could not write debug dump %s: %v", file, err)
+	}
+}
+
+func dumpHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range dumpSecretHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "<redacted>")
+		}
+	}
+	return header
+}
+
+// dumpBody decodes the JSON (or the JSON lines of a streamed answer) and
+// elides the images, other bodies are kept as text
+func dumpBody(data []byte) any {
+	if len(data) == 0 {
+		return nil
+	}
+	var value any
+	if json.Unmarshal(data, &value) == nil {
+		return elideImages(value)
+	}
+	var lines []any
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var value any
+		if json.Unmarshal(line, &value) != nil {
+			return elideString(string(data))
+		}
+		lines = append(lines, elideImages(value))
+	}
+	return lines
+}
+
+func elideImages(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = elideImages(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = elideImages(item)
+		}
+	case string:
+		return elideString(v)
+	}
+	return value
+}
+
+// elideString keeps the start of long strings like "data:image/jpeg;base64,/9j/4AAQ… (183210 bytes)"
+func elideString(s string) string {
+	if len(s) <= dumpMaxString || strings.ContainsAny(s, " \n") {
+		return s
+	}
+	keep := 64
+	if strings.HasPrefix(s, "data:") {
+		keep += strings.IndexByte(s, ',') + 1
+	}
+	return fmt.Sprintf("%s… (%d bytes)", s[:min(keep, len(s))], len(s))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// dHash is the difference hash of an image: the image is scaled down to 9x8
+// gray pixels and every bit tells if a pixel is brighter than its right neighbour
+func dHash(path string) (uint64, error) {
+	data, err := readImage(path)
+	if err != nil {
+		return 0, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// hashImages hashes the images on all CPUs, failed tells which couldn't be hashed
+func hashImages(todo []string) ([]uint64, []bool) {
+	hashes := make([]uint64, len(todo))
+	failed := make([]bool, len(todo))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				var err error
+				hashes[i], err = dHash(todo[i])
+				if err != nil {
+					// the image is captioned anyway and fails there if it is broken
+					logVerbose("No perceptual hash for %s: %v", todo[i], err)
+					failed[i] = true
+				}
+			}
+		}()
+	}
+	for i := range todo {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return hashes, failed
+}
+
+// dedupeImages hashes the images and keeps the first image of each group of
+// near-duplicates, the others are returned with the image they duplicate
+func dedupeImages(args args, todo []string, root string) ([]string, map[string]string) {
+	hashes, failed := hashImages(todo)
+	var keep []string
+	var kept []int
+	duplicates := map[string]string{}
+	for i, path := range todo {
+		if !failed[i] {
+			original := -1
+			for _, k := range kept {
+				if bits.OnesCount64(hashes[i]^hashes[k]) <= args.DedupeDistance {
+					original = k
+					break
+				}
+			}
+			if original >= 0 {
+				logVerbose("%s is a near-duplicate of %s", strings.TrimPrefix(path, root), strings.TrimPrefix(todo[original], root))
+				duplicates[path] = todo[original]
+				continue
+			}
+			kept = append(kept, i)
+		}
+		keep = append(keep, path)
+	}
+	return keep, duplicates
+}
+
+// copyDuplicates gives each near-duplicate (or frame of a burst) the caption
+// files of its original and returns how many got them
+func copyDuplicates(args args, duplicates map[string]string, root string) (int, error) {
+	copied := 0
+	for path, original := range duplicates {
+		if len(outputFiles(args, original)) == 0 {
+			// the original failed
+			continue
+		}
+		caption, _ := readCaption(captionFile(original))
+		printResult(args, result{Path: path, Caption: caption}, root)
+		if args.DryRun {
+			continue
+		}
+		// the files of both images are named the same way, like for a caption stream
+		var written []string
+		for _, name := range outputNames(args) {
+			file, dest := name(original), name(path)
+			if !fileExists(file) || contains(written, dest) {
+				continue
+			}
+			written = append(written, dest)
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return copied, err
+			}
+			err = writeOutput(dest, string(data))
+			if err != nil {
+				return copied, fmt.Errorf("could not write file: %w", err)
+			}
+		}
+		copied++
+	}
+	return copied, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	colorRemoved = "\x1b[31m"
+	colorAdded   = "\x1b[32m"
+	colorReset   = "\x1b[0m"
+)
+
+// printDiff shows the changes of the new caption against the existing one for
+// --diff, in color on a terminal and as [-removed-]{+added+} otherwise
+func printDiff(args args, res result, root string, old string) {
+	changed := old != res.Caption
+	stats.diffed(res.Path, changed)
+	record := strings.TrimPrefix(res.Path, root) + " (unchanged): " + res.Caption
+	if changed {
+		color := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+		record = strings.TrimPrefix(res.Path, root) + ": " + wordDiff(old, res.Caption, color)
+	}
+	withProgressCleared(func() {
+		printBetweenStreams(record + "\n")
+	})
+}
+
+// wordDiff marks the words that were removed and added, based on the longest
+// common subsequence of the words
+func wordDiff(old string, new string, color bool) string {
+	a, b := strings.Fields(old), strings.Fields(new)
+	// common[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var out, removed, added []string
+	mark := func(words []string, start string, end string, ansi string) string {
+		text := strings.Join(words, " ")
+		if color {
+			return ansi + text + colorReset
+		}
+		return start + text + end
+	}
+	flush := func() {
+		if len(removed) > 0 {
+			out = append(out, mark(removed, "[-", "-]", colorRemoved))
+		}
+		if len(added) > 0 {
+			out = append(out, mark(added, "{+", "+}", colorAdded))
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			out = append(out, a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || common[i][j+1] >= common[i+1][j]):
+			added = append(added, b[j])
+			j++
+		default:
+			removed = append(removed, a[i])
+			i++
+		}
+	}
+	flush()
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// dualSuffix is the file of the long description of --mode dual, the short caption goes to the .txt
+const dualSuffix = ".long.txt"
+
+const dualPrompt = "Describe this image."
+
+// dualFormatHint is appended to the prompt of --mode dual, so both texts come in one answer
+const dualFormatHint = " Answer with a JSON object with the fields \"short\" (one sentence that is short enough for an alt text) and \"long\" (a detailed paragraph about the content, the background and the style)."
+
+type dualAnswer struct {
+	Short string `json:"short"`
+	Long  string `json:"long"`
+}
+
+// parseDual reads the structured answer of --mode dual
+func parseDual(answer string) (dualAnswer, error) {
+	var dual dualAnswer
+	err := json.Unmarshal([]byte(answer), &dual)
+	if err != nil {
+		return dual, fmt.Errorf("invalid answer %q: %w", answer, err)
+	}
+	dual.Short = strings.TrimSpace(dual.Short)
+	dual.Long = strings.TrimSpace(dual.Long)
+	if dual.Short == "" || dual.Long == "" {
+		return dual, fmt.Errorf("the answer %q misses the short or long text", answer)
+	}
+	return dual, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// The exit codes, so scripts can tell the classes of failures apart
+const (
+	exitOK       = 0 // every image has its caption
+	exitError    = 1 // the run stopped with an error, like a caption that could not be written
+	exitConfig   = 2 // invalid flags, configuration or input files
+	exitBackend  = 3 // the backend is unreachable or the model is missing
+	exitNoImages = 4 // PATH has no images
+	exitPartial  = 5 // the run finished, but some images failed (or are poisoned)
+)
+
+var errNoHosts = errors.New("no reachable Ollama host left")
+
+// exitCode is the exit code of an error that stopped the run
+func exitCode(err error) int {
+	if errors.Is(err, errNoHosts) || isUnreachable(err) {
+		return exitBackend
+	}
+	return exitError
+}
+
+// exitWith logs the error and exits with the code of its class
+func exitWith(code int, err error) {
+	log.Printf("Error: %s", err.Error())
+	os.Exit(code)
+}
+
+// exitCode is the exit code of a finished run
+func (s *runStats) exitCode() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.Failed > 0 || s.SkippedPoisoned > 0:
+		return exitPartial
+	case s.Processed == 0 && s.SkippedExisting == 0 && s.SkippedImported == 0 && len(s.Duplicates) == 0:
+		return exitNoImages
+	}
+	return exitOK
+}
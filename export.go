@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var exportFormats = []string{"kohya", "hf", "llava"}
+
+type exportArgs struct {
+	Format        string  `arg:"positional,required" help:"Format of the export: kohya (metadata JSON for the finetuning scripts of kohya_ss), hf (Hugging Face imagefolder dataset) or llava (conversations as JSON lines for LLaVA and ShareGPT recipes)"`
+	Path          string  `arg:"positional,required" help:"Directory with the captioned images"`
+	Output        string  `arg:"--output,-o" help:"File that is written for kohya (default metadata.json) and llava (default llava.jsonl), the dataset directory for hf (default is metadata.jsonl in PATH)"`
+	CaptionSuffix string  `arg:"--caption-suffix" help:"Suffix of the caption files" default:".txt"`
+	TagsSuffix    string  `arg:"--tags-suffix" help:"Suffix of the tag files (like .tags.txt) that are exported as tags"`
+	FullPath      bool    `arg:"--full-path" help:"Use the absolute paths of the images instead of the paths relative to PATH (kohya, llava)"`
+	Prompt        string  `arg:"--prompt,-p" help:"The question of the human in the llava conversations (the prompt of a .capollama.toml wins)"`
+	ValSplit      float64 `arg:"--val-split" help:"Part of the images that goes to the validation split (hf, needs --output)"`
+	Seed          int64   `arg:"--seed" help:"The seed for choosing the validation images" default:"1"`
+}
+
+func (exportArgs) Description() string {
+	return "Exports the captions of a directory for training tools\n"
+}
+
+// exportItem is a captioned image
+type exportItem struct {
+	Path    string
+	Caption string
+	Tags    string
+}
+
+// kohyaEntry is the value of an image key in the metadata of kohya_ss
+type kohyaEntry struct {
+	Caption string `json:"caption,omitempty"`
+	Tags    string `json:"tags,omitempty"`
+}
+
+// llavaRecord is a line of the conversation JSON lines of LLaVA and ShareGPT
+type llavaRecord struct {
+	ID            string      `json:"id"`
+	Image         string      `json:"image"`
+	Conversations []llavaTurn `json:"conversations"`
+}
+
+type llavaTurn struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+// hfRow is a line of the metadata.jsonl of a Hugging Face imagefolder
+type hfRow struct {
+	FileName string  `json:"file_name"`
+	Text     string  `json:"text"`
+	Tags     *string `json:"tags,omitempty"` // all rows need the same columns
+}
+
+func runExport(cmdline []string) {
+	ea := exportArgs{Prompt: defaultPrompt}
+	p := newParser(appName+" export", &ea)
+	p.MustParse(cmdline)
+	if !contains(exportFormats, ea.Format) {
+		p.Fail(fmt.Sprintf("unknown export format %q", ea.Format))
+	}
+	if ea.ValSplit < 0 || ea.ValSplit >= 1 {
+		p.Fail("--val-split must be between 0 and 1")
+	}
+	if ea.ValSplit > 0 && (ea.Format != "hf" || ea.Output == "") {
+		p.Fail("--val-split only works for hf with --output")
+	}
+
+	items, root, err := exportItems(ea)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	switch ea.Format {
+	case "kohya":
+		err = exportKohya(ea, items, root)
+	case "hf":
+		err = exportHF(ea, items, root)
+	case "llava":
+		err = exportLLaVA(ea, items, root)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// exportItems collects the images of PATH that have a caption
+func exportItems(ea exportArgs) ([]exportItem, string, error) {
+	images, root, err := CollectImages(ea.Path, walkOptions{Order: "name"})
+	if err != nil {
+		return nil, "", err
+	}
+	var items []exportItem
+	missing := 0
+	for _, image := range images {
+		item := exportItem{Path: image.Path}
+		item.Caption, err = readCaption(outputFile(image.Path, ea.CaptionSuffix))
+		if os.IsNotExist(err) {
+			logVerbose("Skipping %s without caption", image.Path)
+			missing++
+			continue
+		}
+		if err == nil && ea.TagsSuffix != "" {
+			// images without tags are exported with only the caption
+			item.Tags, err = readCaption(outputFile(image.Path, ea.TagsSuffix))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+	}
+	if missing > 0 {
+		logInfo("Skipped %d images without caption", missing)
+	}
+	return items, root, nil
+}
+
+func exportKohya(ea exportArgs, items []exportItem, root string) error {
+	output := ea.Output
+	if output == "" {
+		output = "metadata.json"
+	}
+	meta := map[string]kohyaEntry{}
+	for _, item := range items {
+		key, err := kohyaKey(item.Path, root, ea.FullPath)
+		if err != nil {
+			return err
+		}
+		meta[key] = kohyaEntry{Caption: item.Caption, Tags: item.Tags}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(output, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", output, err)
+	}
+	logInfo("Exported %d images to %s", len(meta), output)
+	return nil
+}
+
+// exportHF writes the metadata.jsonl of an imagefolder dataset. Without
+// --output it is written into PATH, which then can be loaded as it is. With
+// --output the images are linked (or copied) into the train and validation
+// directories of a new dataset.
+func exportHF(ea exportArgs, items []exportItem, root string) error {
+	if ea.Output == "" {
+		return writeHFSplit(ea, root, root, items, false)
+	}
+	var validation []exportItem
+	if ea.ValSplit > 0 && len(items) > 1 {
+		shuffled := make([]exportItem, len(items))
+		copy(shuffled, items)
+		rand.New(rand.NewSource(ea.Seed)).Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		n := min(max(int(math.Round(float64(len(items))*ea.ValSplit)), 1), len(items)-1)
+		validation, items = shuffled[:n], shuffled[n:]
+		sortItems(validation)
+		sortItems(items)
+	}
+	err := writeHFSplit(ea, filepath.Join(ea.Output, "train"), root, items, true)
+	if err == nil && len(validation) > 0 {
+		err = writeHFSplit(ea, filepath.Join(ea.Output, "validation"), root, validation, true)
+	}
+	return err
+}
+
+func sortItems(items []exportItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+}
+
+func writeHFSplit(ea exportArgs, dir string, root string, items []exportItem, link bool) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "metadata.jsonl")
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, item := range items {
+		rel, err := filepath.Rel(root, item.Path)
+		if err != nil {
+			return err
+		}
+		if link {
+			err = linkFile(item.Path, filepath.Join(dir, rel))
+			if err != nil {
+				return err
+			}
+		}
+		row := hfRow{FileName: filepath.ToSlash(rel), Text: item.Caption}
+		if ea.TagsSuffix != "" {
+			row.Tags = &item.Tags
+		}
+		err = enc.Encode(row)
+		if err != nil {
+			return err
+		}
+	}
+	logInfo("Exported %d images to %s", len(items), file)
+	return f.Close()
+}
+
+// linkFile hard links the image into the dataset and copies it if that is
+// not possible (like across file systems)
+func linkFile(src string, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+	os.Remove(dst)
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// exportLLaVA writes a conversation per image where the human asks the
+// prompt with the image and gpt answers with the caption
+func exportLLaVA(ea exportArgs, items []exportItem, root string) error {
+	output := ea.Output
+	if output == "" {
+		output = "llava.jsonl"
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	for _, item := range items {
+		image, err := filepath.Rel(root, item.Path)
+		if ea.FullPath {
+			image, err = filepath.Abs(item.Path)
+		}
+		if err != nil {
+			return err
+		}
+		imageArgs, err := overrides.apply(args{Prompt: ea.Prompt}, item.Path, root)
+		if err != nil {
+			return err
+		}
+		image = filepath.ToSlash(image)
+		err = enc.Encode(llavaRecord{
+			ID:    strings.TrimSuffix(image, filepath.Ext(image)),
+			Image: image,
+			Conversations: []llavaTurn{
+				{From: "human", Value: "<image>\n" + imageArgs.Prompt},
+				{From: "gpt", Value: item.Caption},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	logInfo("Exported %d images to %s", len(items), output)
+	return f.Close()
+}
+
+func readCaption(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	return strings.TrimSpace(string(data)), err
+}
+
+// kohyaKey is the image path without extension relative to the training
+// directory, which is how kohya finds the image and its bucket
+func kohyaKey(path string, root string, fullPath bool) (string, error) {
+	key := strings.TrimSuffix(path, filepath.Ext(path))
+	if fullPath {
+		return filepath.Abs(key)
+	}
+	rel, err := filepath.Rel(root, key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+// fallback is the pool of --fallback-model, nil without it
+var fallback *hostPool
+
+// newFallbackPool returns the pool of --fallback-host, without it the pool of
+// --model is used for Ollama and the local Ollama for the other backends
+func newFallbackPool(ol *hostPool, args args, limits map[string]backendLimit) (*hostPool, error) {
+	if args.FallbackHost != "" {
+		return newHostPool([]string{args.FallbackHost}, args.StreamUpload, limits)
+	}
+	if args.AzureEndpoint == "" && args.LlamaCpp == "" {
+		return ol, nil
+	}
+	return newHostPool(defaultHosts(nil), false, limits)
+}
+
+// processFallback captions an image that failed with --fallback-model
+func processFallback(args args, path string, root string) error {
+	args.Model = args.FallbackModel
+	args.Models = []string{args.FallbackModel}
+	args.keepModel = true
+	err := processImage(fallback, args, path, root, captionFile(path))
+	if err == nil {
+		stats.fellBack(path)
+	}
+	return err
+}
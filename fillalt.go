@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const backupSuffix = ".bak"
+
+// altAttr matches the alt attribute of a raw img tag
+var altAttr = regexp.MustCompile(`(?i)(\salt\s*=\s*)("[^"]*"|'[^']*'|[^\s"'>]*)`)
+
+// FillAlt adds the alt texts of the img tags without one to the HTML files of
+// PATH (a file or a folder). The files are rewritten in place and the
+// original is kept as FILE.html.bak, or with --patch a unified diff is written
+// instead.
+func FillAlt(ol *hostPool, args args) error {
+	root := args.Path
+	var pages []string
+	info, err := os.Stat(args.Path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		pages, err = sitePages(args.Path)
+		if err != nil {
+			return err
+		}
+	} else {
+		root = filepath.Dir(args.Path)
+		pages = []string{filepath.Base(args.Path)}
+	}
+
+	var patch io.Writer
+	if args.Patch == "-" {
+		patch = os.Stdout
+	} else if args.Patch != "" {
+		out, err := os.Create(args.Patch)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		patch = out
+	}
+
+	prompt := altPrompt(args)
+	// images are often used on many pages, so we caption each only once
+	captions := map[string]string{}
+	alt := func(page string, src string) (string, bool) {
+		image, err := resolveSiteImage(page, src)
+		if err != nil || isURL(image) {
+			logVerbose("Skipping image %q on %s, it is no local file", src, page)
+			return "", false
+		}
+		if captionText, ok := captions[image]; ok {
+			return captionText, true
+		}
+		imgData, err := readImage(filepath.Join(root, filepath.FromSlash(image)))
+		if err == nil {
+			imgData, err = Preprocess(imgData, args.steps)
+		}
+		if err == nil {
+			captions[image], err = suggestAlt(ol, args, prompt, imgData)
+		}
+		if err != nil {
+			logInfo("Skipping image %s on %s: %v", image, page, err)
+			return "", false
+		}
+		printResult(args, result{Page: page, Path: image, Caption: captions[image]}, "")
+		return captions[image], true
+	}
+
+	for _, page := range pages {
+		file := filepath.Join(root, filepath.FromSlash(page))
+		body, err := os.ReadFile(file)
+		if err != nil {
+			logInfo("Skipping page %s: %v", page, err)
+			continue
+		}
+		filled, n := fillAltTags(body, func(src string) (string, bool) { return alt(page, src) })
+		if n == 0 {
+			continue
+		}
+		logVerbose("Filled %d alt texts in %s", n, page)
+		switch {
+		case patch != nil:
+			err = writePatch(patch, page, body, filled)
+		case args.DryRun:
+		default:
+			// the first backup keeps the original when the file is filled again
+			if !fileExists(file + backupSuffix) {
+				err = os.WriteFile(file+backupSuffix, body, 0644)
+			}
+			if err == nil {
+				err = os.WriteFile(file, filled, 0644)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("could not write %s: %w", page, err)
+		}
+	}
+	return nil
+}
+
+// fillAltTags returns the HTML with the alt texts of the img tags without a
+// usable one, everything else is kept byte by byte. Images that are marked as
+// decorative (role="presentation" or aria-hidden) keep their empty alt.
+func fillAltTags(body []byte, alt func(src string) (string, bool)) ([]byte, int) {
+	var out bytes.Buffer
+	var n int
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// the rest of a broken document is kept as is
+			out.Write(z.Raw())
+			return out.Bytes(), n
+		}
+		raw := append([]byte{}, z.Raw()...)
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+		tok := z.Token()
+		if tok.Data != "img" {
+			out.Write(raw)
+			continue
+		}
+		var src string
+		hasAlt, decorative := false, false
+		altText := ""
+		for _, attr := range tok.Attr {
+			switch attr.Key {
+			case "src":
+				src = strings.TrimSpace(attr.Val)
+			case "alt":
+				hasAlt = true
+				altText = strings.TrimSpace(attr.Val)
+			case "role":
+				decorative = decorative || attr.Val == "presentation" || attr.Val == "none"
+			case "aria-hidden":
+				decorative = decorative || attr.Val == "true"
+			}
+		}
+		if src == "" || strings.HasPrefix(src, "data:") || altText != "" || hasAlt && decorative {
+			out.Write(raw)
+			continue
+		}
+		text, ok := alt(src)
+		if !ok {
+			out.Write(raw)
+			continue
+		}
+		value := `"` + html.EscapeString(text) + `"`
+		if loc := altAttr.FindSubmatchIndex(raw); hasAlt && loc != nil {
+			out.Write(raw[:loc[3]])
+			out.WriteString(value)
+			out.Write(raw[loc[5]:])
+		} else {
+			// right after <img
+			out.Write(raw[:4])
+			out.WriteString(" alt=" + value)
+			out.Write(raw[4:])
+		}
+		n++
+	}
+}
+
+// writePatch writes the changes as unified diff with three lines of context.
+// Filling alt texts never adds or removes lines, so the lines of both
+// versions match one to one.
+func writePatch(w io.Writer, page string, before []byte, after []byte) error {
+	a := strings.SplitAfter(string(before), "\n")
+	b := strings.SplitAfter(string(after), "\n")
+	if len(a) != len(b) {
+		return fmt.Errorf("the lines of %s changed", page)
+	}
+	const context = 3
+	var changed []int
+	for i := range a {
+		if a[i] != b[i] {
+			changed = append(changed, i)
+		}
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", path.Join("a", page), path.Join("b", page))
+	for len(changed) > 0 {
+		start := max(changed[0]-context, 0)
+		end := changed[0]
+		// the hunk takes the changes that are close enough to share context
+		for len(changed) > 0 && changed[0] <= end+2*context {
+			end = changed[0]
+			changed = changed[1:]
+		}
+		end = min(end+context, len(a)-1)
+		if a[end] == "" {
+			// the empty rest after the last newline
+			end--
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", start+1, end-start+1, start+1, end-start+1)
+		for i := start; i <= end; i++ {
+			if a[i] == b[i] {
+				fmt.Fprint(w, " "+patchLine(a[i]))
+				continue
+			}
+			fmt.Fprint(w, "-"+patchLine(a[i]))
+			fmt.Fprint(w, "+"+patchLine(b[i]))
+		}
+	}
+	return nil
+}
+
+// patchLine ends the line with a newline (and the marker of diff if the file has none)
+func patchLine(line string) string {
+	if strings.HasSuffix(line, "\n") {
+		return line
+	}
+	return line + "\n\\ No newline at end of file\n"
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+)
+
+// the xattr tool of macOS writes the extended attributes, so there is no cgo
+const xattrTool = "xattr"
+
+// Spotlight indexes the metadata attributes, Finder shows the tags
+const (
+	finderCommentAttr = "com.apple.metadata:kMDItemFinderComment"
+	finderTagsAttr    = "com.apple.metadata:_kMDItemUserTags"
+)
+
+// writeFinderMetadata writes the caption as Finder comment and the tags as
+// Finder tags of the image. Tags set by the user (and their colors) are kept.
+func writeFinderMetadata(args args, path string, caption string, tags []string) error {
+	if args.Mode != "tags" && caption != "" {
+		err := setXattr(path, finderCommentAttr, bplist(caption))
+		if err != nil {
+			return err
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	existing, _ := finderTags(path)
+	merged := existing
+	for _, tag := range tags {
+		if !containsTag(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	if len(merged) == len(existing) {
+		return nil
+	}
+	items := make([]any, len(merged))
+	for i, tag := range merged {
+		items[i] = tag
+	}
+	return setXattr(path, finderTagsAttr, bplist(items))
+}
+
+// containsTag compares the names of Finder tags, which end with a newline and
+// the color number ("Red\n6")
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		name, _, _ := strings.Cut(t, "\n")
+		if strings.EqualFold(name, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func setXattr(path string, name string, value []byte) error {
+	out, err := exec.Command(xattrTool, "-wx", name, hex.EncodeToString(value), path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not set %s of %s: %v %s", name, path, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// finderTags reads the Finder tags of the file
+func finderTags(path string) ([]string, error) {
+	out, err := exec.Command(xattrTool, "-px", finderTagsAttr, path).Output()
+	if err != nil {
+		// the file has no tags
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(out)), ""))
+	if err != nil {
+		return nil, err
+	}
+	return readBplistStrings(data)
+}
+
+// bplist encodes a string or an array of strings as binary property list,
+// the format of the metadata attributes
+func bplist(value any) []byte {
+	var objects [][]byte
+	switch v := value.(type) {
+	case string:
+		objects = append(objects, bplistString(v))
+	case []any:
+		// the array refers to the strings that follow it with one byte refs
+		v = v[:min(len(v), 254)]
+		refs := []byte{}
+		for i := range v {
+			refs = append(refs, byte(i+1))
+		}
+		objects = append(objects, append(bplistMarker(0xA0, len(v)), refs...))
+		for _, item := range v {
+			objects = append(objects, bplistString(item.(string)))
+		}
+	}
+	out := []byte("bplist00")
+	var offsets []uint16
+	for _, object := range objects {
+		offsets = append(offsets, uint16(len(out)))
+		out = append(out, object...)
+	}
+	tableOffset := len(out)
+	for _, offset := range offsets {
+		out = binary.BigEndian.AppendUint16(out, offset)
+	}
+	// trailer: offset size 2, ref size 1, object count, top object, table offset
+	out = append(out, 0, 0, 0, 0, 0, 0, 2, 1)
+	out = binary.BigEndian.AppendUint64(out, uint64(len(objects)))
+	out = binary.BigEndian.AppendUint64(out, 0)
+	out = binary.BigEndian.AppendUint64(out, uint64(tableOffset))
+	return out
+}
+
+func bplistString(s string) []byte {
+	ascii := true
+	for _, r := range s {
+		if r > 0x7f {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return append(bplistMarker(0x50, len(s)), s...)
+	}
+	units := utf16.Encode([]rune(s))
+	out := bplistMarker(0x60, len(units))
+	for _, u := range units {
+		out = binary.BigEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+// bplistMarker is the type with the length, lengths from 15 follow as int
+func bplistMarker(kind byte, n int) []byte {
+	if n < 15 {
+		return []byte{kind | byte(n)}
+	}
+	if n < 256 {
+		return []byte{kind | 0x0f, 0x10, byte(n)}
+	}
+	return binary.BigEndian.AppendUint16([]byte{kind | 0x0f, 0x11}, uint16(n))
+}
+
+// readBplistStrings reads the strings of the array at the top of a binary
+// property list
+func readBplistStrings(data []byte) ([]string, error) {
+	if len(data) < 40 || !bytes.HasPrefix(data, []byte("bplist00")) {
+		return nil, fmt.Errorf("no binary property list")
+	}
+	trailer := data[len(data)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	count := binary.BigEndian.Uint64(trailer[8:])
+	top := binary.BigEndian.Uint64(trailer[16:])
+	table := binary.BigEndian.Uint64(trailer[24:])
+	readInt := func(b []byte) uint64 {
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	}
+	object := func(ref uint64) ([]byte, error) {
+		start := table + ref*uint64(offsetSize)
+		if ref >= count || start+uint64(offsetSize) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid object %d", ref)
+		}
+		offset := readInt(data[start : start+uint64(offsetSize)])
+		if offset >= uint64(len(data)) {
+			return nil, fmt.Errorf("invalid offset of object %d", ref)
+		}
+		return data[offset:], nil
+	}
+	length := func(b []byte) (int, []byte, error) {
+		n := int(b[0] & 0x0f)
+		if n < 15 {
+			return n, b[1:], nil
+		}
+		if len(b) < 2 {
+			return 0, nil, fmt.Errorf("invalid length")
+		}
+		size := 1 << (b[1] & 0x0f)
+		if len(b) < 2+size {
+			return 0, nil, fmt.Errorf("invalid length")
+		}
+		return int(readInt(b[2 : 2+size])), b[2+size:], nil
+	}
+
+	arr, err := object(top)
+	if err != nil {
+		return nil, err
+	}
+	if arr[0]&0xf0 != 0xA0 {
+		return nil, fmt.Errorf("no array")
+	}
+	n, refs, err := length(arr)
+	if err != nil || len(refs) < n*refSize {
+		return nil, fmt.Errorf("invalid array")
+	}
+	var strs []string
+	for i := 0; i < n; i++ {
+		b, err := object(readInt(refs[i*refSize : (i+1)*refSize]))
+		if err != nil {
+			return nil, err
+		}
+		kind := b[0] & 0xf0
+		size, b, err := length(b)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case kind == 0x50 && len(b) >= size:
+			strs = append(strs, string(b[:size]))
+		case kind == 0x60 && len(b) >= 2*size:
+			units := make([]uint16, size)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(b[2*j:])
+			}
+			strs = append(strs, string(utf16.Decode(units)))
+		}
+	}
+	return strs, nil
+}
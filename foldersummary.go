@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const folderSummaryPrompt = "These are the descriptions of %d images of the folder %q:\n\n%s\nDescribe the content of the folder as a whole, like the event, the places and the people or the topic, in a short paragraph. Answer only with the description."
+
+const folderGridHint = " The image is a grid of thumbnails of the folder."
+
+const (
+	// the captions of the larger folders are sampled, so the prompt fits the context
+	summaryCaptions = 100
+	gridColumns     = 4
+	gridImages      = gridColumns * gridColumns
+)
+
+// summarizeFolders writes --folder-summary-file into every folder with
+// captioned images. A summary is written again once a caption of its folder
+// is newer than the summary.
+func summarizeFolders(ol *hostPool, args args, root string) error {
+	images, _, err := collectImages(args, nil)
+	if err != nil {
+		return err
+	}
+	var folders []string
+	byFolder := map[string][]string{}
+	for _, image := range images {
+		dir := filepath.Dir(image.Path)
+		if byFolder[dir] == nil {
+			folders = append(folders, dir)
+		}
+		byFolder[dir] = append(byFolder[dir], image.Path)
+	}
+	for _, dir := range folders {
+		err = summarizeFolder(ol, args, root, dir, byFolder[dir])
+		if err != nil {
+			return fmt.Errorf("summary of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func summarizeFolder(ol *hostPool, args args, root string, dir string, files []string) error {
+	file := filepath.Join(dir, args.FolderSummaryFile)
+	summary, err := os.Stat(file)
+	stale := args.Force || err != nil
+	var paths, captions []string
+	for _, path := range files {
+		info, err := os.Stat(captionFile(path))
+		if err != nil {
+			continue
+		}
+		caption, err := readCaption(captionFile(path))
+		if err != nil || caption == "" {
+			continue
+		}
+		stale = stale || info.ModTime().After(summary.ModTime())
+		paths = append(paths, path)
+		captions = append(captions, caption)
+	}
+	if len(captions) == 0 || !stale {
+		return nil
+	}
+
+	var list strings.Builder
+	for _, i := range spread(len(captions), summaryCaptions) {
+		fmt.Fprintf(&list, "%s: %s\n", filepath.Base(paths[i]), captions[i])
+	}
+	prompt := fmt.Sprintf(folderSummaryPrompt, len(captions), filepath.Base(dir), list.String())
+	var images [][]byte
+	if args.FolderSummaryGrid {
+		var grid []string
+		for _, i := range spread(len(paths), gridImages) {
+			grid = append(grid, paths[i])
+		}
+		sheet, err := thumbnailGrid(grid)
+		if err != nil {
+			return err
+		}
+		prompt += folderGridHint
+		images = append(images, sheet)
+	}
+	// the model and language of the .capollama.toml files of the folder
+	args, err = overrides.apply(args, file, root)
+	if err != nil {
+		return err
+	}
+	logVerbose("Summarizing the %d captions of %s", len(captions), logPath(dir))
+	var usage tokenUsage
+	answer, err := askInLanguage(ol, args, &usage, prompt+localeHint(args), images...)
+	if err != nil {
+		return err
+	}
+	answer = strings.TrimSpace(answer)
+	printResult(args, result{Path: file, Caption: answer}, root)
+	if args.DryRun {
+		return nil
+	}
+	return writeOutput(file, answer)
+}
+
+// spread picks up to max indexes of n evenly, in order
+func spread(n int, max int) []int {
+	var picked []int
+	if n <= max {
+		for i := 0; i < n; i++ {
+			picked = append(picked, i)
+		}
+		return picked
+	}
+	for i := 0; i < max; i++ {
+		picked = append(picked, i*n/max)
+	}
+	return picked
+}
+
+// thumbnailGrid places the thumbnails of the images in a JPEG with
+// gridColumns columns, the images that can't be decoded stay empty
+func thumbnailGrid(paths []string) ([]byte, error) {
+	columns := min(len(paths), gridColumns)
+	rows := (len(paths) + columns - 1) / columns
+	sheet := image.NewNRGBA(image.Rect(0, 0, columns*thumbnailSize, rows*thumbnailSize))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.NRGBA{32, 32, 32, 255}), image.Point{}, draw.Src)
+	for i, path := range paths {
+		data, err := readImage(path)
+		if err != nil {
+			logVerbose("No thumbnail for %s: %v", path, err)
+			continue
+		}
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			logVerbose("No thumbnail for %s: %v", path, err)
+			continue
+		}
+		thumb := resizeToFit(orient(toNRGBA(src), exifOrientation(data)), thumbnailSize)
+		size := thumb.Bounds().Size()
+		// centered in its cell
+		at := image.Pt(i%columns*thumbnailSize+(thumbnailSize-size.X)/2, i/columns*thumbnailSize+(thumbnailSize-size.Y)/2)
+		draw.Draw(sheet, image.Rectangle{at, at.Add(size)}, thumb, thumb.Bounds().Min, draw.Src)
+	}
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -3,8 +3,11 @@ module github.com/oderwat/capollama
 go 1.22.5
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/alexflint/go-arg v1.5.1
 	github.com/ollama/ollama v0.3.14
+	golang.org/x/image v0.21.0
+	golang.org/x/net v0.30.0
 )
 
 require github.com/alexflint/go-scalar v1.2.0 // indirect
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// hostEnv overrides OLLAMA_HOST for capollama only, --host overrides both
+const hostEnv = "CAPOLLAMA_HOST"
+
+// defaultHosts falls back to CAPOLLAMA_HOST if no --host is given
+func defaultHosts(hosts []string) []string {
+	if len(hosts) == 0 && os.Getenv(hostEnv) != "" {
+		return []string{os.Getenv(hostEnv)}
+	}
+	return hosts
+}
+
+// ollamaHost is one Ollama server (or other backend) of the pool
+type ollamaHost struct {
+	name        string
+	url         *url.URL
+	client      ollamaAPI
+	ollama      *api.Client // nil for other backends
+	inflight    int
+	maxInflight int
+	limit       *rateLimiter
+	down        bool
+}
+
+// hostPool spreads the requests across the Ollama hosts given with --host.
+// Hosts that become unreachable are skipped for the rest of the run.
+type hostPool struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	hosts []*ollamaHost
+}
+
+// backendLimit is the concurrency of one backend set with --backend-limit
+type backendLimit struct {
+	MaxInflight int
+	RPM         int
+}
+
+// parseBackendLimits parses the limits in the form NAME=INFLIGHT[:RPM]
+func parseBackendLimits(specs []string) (map[string]backendLimit, error) {
+	limits := map[string]backendLimit{}
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		inflight, rpm, hasRPM := strings.Cut(value, ":")
+		var limit backendLimit
+		var err error
+		limit.MaxInflight, err = strconv.Atoi(inflight)
+		if err == nil && hasRPM {
+			limit.RPM, err = strconv.Atoi(rpm)
+		}
+		if !ok || name == "" || err != nil || limit.MaxInflight < 0 || limit.RPM < 0 {
+			return nil, fmt.Errorf("invalid backend limit %q (use NAME=INFLIGHT[:RPM])", spec)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// newPool creates the pool and applies the limits to the backends with the same name
+func newPool(hosts []*ollamaHost, limits map[string]backendLimit) (*hostPool, error) {
+	p := &hostPool{hosts: hosts}
+	p.cond = sync.NewCond(&p.mu)
+	for name, limit := range limits {
+		found := false
+		for _, h := range hosts {
+			if name == h.name || name == h.url.Host || name == h.url.String() {
+				h.maxInflight = limit.MaxInflight
+				h.limit = newRateLimiter(limit.RPM, 0)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("--backend-limit for unknown backend %q", name)
+		}
+	}
+	for _, h := range hosts {
+		if h.limit == nil {
+			h.limit = newRateLimiter(0, 0)
+		}
+	}
+	return p, nil
+}
+
+// newHostPool creates the pool for the hosts (a list may also be comma separated).
+// Without hosts the server from OLLAMA_HOST (or the default) is used.
+func newHostPool(hosts []string, stream bool, limits map[string]backendLimit) (*hostPool, error) {
+	var names []string
+	var urls []*url.URL
+	for _, list := range hosts {
+		for _, host := range strings.Split(list, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			u, err := parseHost(host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid host %q: %w", host, err)
+			}
+			names = append(names, host)
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		names = append(names, "ollama")
+		urls = append(urls, envconfig.Host())
+	}
+
+	var pool []*ollamaHost
+	for i, u := range urls {
+		client := api.NewClient(u, backendClient)
+		h := &ollamaHost{name: names[i], url: u, client: client, ollama: client}
+		if stream {
+			h.client = newStreamingClient(u)
+		}
+		pool = append(pool, h)
+	}
+	return newPool(pool, limits)
+}
+
+// newAzurePool sends all requests to one Azure OpenAI deployment
+func newAzurePool(endpoint string, deployment string, apiVersion string, limits map[string]backendLimit) (*hostPool, error) {
+	client, err := newAzureClient(endpoint, deployment, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return newPool([]*ollamaHost{{name: "azure", url: u, client: client}}, limits)
+}
+
+// newLlamaCppPool sends all requests to the native API of a llama.cpp server
+func newLlamaCppPool(server string, template string, limits map[string]backendLimit) (*hostPool, error) {
+	u, err := parseHost(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid llama.cpp server %q: %w", server, err)
+	}
+	return newPool([]*ollamaHost{{name: "llamacpp", url: u, client: newLlamaCppClient(u, template)}}, limits)
+}
+
+// parseHost accepts the same forms as OLLAMA_HOST ("host", "host:port" or a URL)
+func parseHost(host string) (*url.URL, error) {
+	defaultPort := "11434"
+	scheme, hostport, ok := strings.Cut(host, "://")
+	switch {
+	case !ok:
+		scheme, hostport = "http", host
+	case scheme == "http":
+		defaultPort = "80"
+	case scheme == "https":
+		defaultPort = "443"
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+
+	hostport, path, _ := strings.Cut(hostport, "/")
+	name, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		name, port = strings.Trim(hostport, "[]"), defaultPort
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing host name")
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(name, port), Path: path}, nil
+}
+
+// acquire picks the reachable host with the fewest requests in flight that is
+// below its --backend-limit and waits for its requests per minute
+func (p *hostPool) acquire() (*ollamaHost, error) {
+	p.mu.Lock()
+	var best *ollamaHost
+	for {
+		reachable := false
+		for _, h := range p.hosts {
+			if h.down {
+				continue
+			}
+			reachable = true
+			if h.maxInflight > 0 && h.inflight >= h.maxInflight {
+				continue
+			}
+			if best == nil || h.inflight < best.inflight {
+				best = h
+			}
+		}
+		if !reachable {
+			p.mu.Unlock()
+			return nil, errNoHosts
+		}
+		if best != nil {
+			break
+		}
+		p.cond.Wait()
+	}
+	best.inflight++
+	p.mu.Unlock()
+	best.limit.acquire()
+	return best, nil
+}
+
+// release returns the host after a request and marks it as down if it could
+// not be reached. It reports if the request may be retried on another host.
+func (p *hostPool) release(h *ollamaHost, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h.inflight--
+	p.cond.Broadcast()
+	if !isUnreachable(err) || len(p.hosts) == 1 {
+		return false
+	}
+	p.markDown(h, err)
+	for _, other := range p.hosts {
+		if !other.down {
+			return true
+		}
+	}
+	return false
+}
+
+// markDown skips the host for the rest of the run, the caller holds the lock
+func (p *hostPool) markDown(h *ollamaHost, err error) {
+	if !h.down {
+		h.down = true
+		logError("Skipping unreachable host %s: %v", h.url, err)
+	}
+}
+
+// isUnreachable checks for network errors, an error answer of the server
+// does not count as unreachable
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
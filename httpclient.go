@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newBackendTransport is the transport of backendClient with the --http-*
+// settings. Go keeps only 2 idle connections per host by default, so with more
+// workers most requests to the same backend would open a new connection.
+func newBackendTransport(args args) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: args.HTTPDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.MaxIdleConns = 0 // no limit over all hosts
+	t.MaxIdleConnsPerHost = args.HTTPMaxIdle
+	t.IdleConnTimeout = args.HTTPIdleTimeout
+	t.ResponseHeaderTimeout = args.HTTPHeaderTimeout
+	t.DisableKeepAlives = args.HTTPNoKeepAlive
+	if args.HTTP1 {
+		// an empty map turns off HTTP/2 for https
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile can be dropped into any folder of PATH to skip folders and
+// images of its subtree, with the syntax of .gitignore
+const ignoreFile = ".capollamaignore"
+
+// ignoreRule is a line of an ignore file
+type ignoreRule struct {
+	pattern  string // slash separated, without the leading and trailing slash
+	negate   bool   // !pattern includes again what an earlier rule excluded
+	dirOnly  bool   // pattern/ only matches folders
+	anchored bool   // a pattern with a slash matches the path relative to the folder of the file, otherwise the name
+}
+
+// ignoreMatcher reads the ignore files of the folders while walking
+type ignoreMatcher struct {
+	files []string                // the names of the ignore files
+	rules map[string][]ignoreRule // the rules of each folder
+	git   bool                    // --respect-gitignore
+	top   string                  // the git work tree of PATH, its rules and those down to PATH apply too
+}
+
+func newIgnoreMatcher(files ...string) *ignoreMatcher {
+	return &ignoreMatcher{files: files, rules: map[string][]ignoreRule{}}
+}
+
+// newGitIgnoreMatcher also uses the .gitignore files and .git/info/exclude of
+// the git work tree that PATH is in, like git status does
+func newGitIgnoreMatcher(root string) *ignoreMatcher {
+	m := newIgnoreMatcher(ignoreFile, ".gitignore")
+	m.git = true
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return m
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if fileExists(filepath.Join(dir, ".git")) {
+			m.top = dir
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return m
+}
+
+func (m *ignoreMatcher) folderRules(dir string) []ignoreRule {
+	rules, ok := m.rules[dir]
+	if ok {
+		return rules
+	}
+	if m.top != "" && dir == m.top {
+		// the .gitignore files come later and win
+		data, err := os.ReadFile(filepath.Join(dir, ".git", "info", "exclude"))
+		if err == nil {
+			rules = parseIgnore(string(data))
+		}
+	}
+	for _, name := range m.files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			rules = append(rules, parseIgnore(string(data))...)
+		}
+	}
+	m.rules[dir] = rules
+	return rules
+}
+
+// ignored checks the rules of the folders from root down to the folder of
+// the path, the last rule that matches decides like with git
+func (m *ignoreMatcher) ignored(root string, file string, isDir bool) bool {
+	if m.git && isDir && filepath.Base(file) == ".git" {
+		return true
+	}
+	if m.top != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return false
+		}
+		root, file = m.top, abs
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	ignored := false
+	dir := root
+	for i := range parts {
+		// the rules of root/parts[:i] match parts[i:]
+		for _, rule := range m.folderRules(dir) {
+			if rule.matches(parts[i:], isDir) {
+				ignored = !rule.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+func parseIgnore(data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		// trailing spaces are ignored unless they are escaped with a backslash
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = matchPattern(strings.TrimPrefix(line, "/"))
+		if rule.pattern != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// matchPattern converts the negated character classes [!a] of git to the
+// [^a] of path.Match
+func matchPattern(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			b.WriteString(pattern[i : i+2])
+			i++
+		case pattern[i] == '[' && strings.HasPrefix(pattern[i+1:], "!"):
+			b.WriteString("[^")
+			i++
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+func (r ignoreRule) matches(parts []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, parts[len(parts)-1])
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), parts)
+}
+
+// matchSegments matches the path segments, ** matches any number of folders.
+// A trailing ** matches everything inside the folder, but not the folder.
+func matchSegments(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" && len(pattern) == 1 {
+		return len(parts) > 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
+
+// listIgnores applies the ignore files to the images of --files-from, with
+// the rules of the folders from the top (the git work tree or the root of the
+// file system) down to the image
+type listIgnores struct {
+	git      bool
+	matchers map[string]*ignoreMatcher // by the folder of the image
+}
+
+func newListIgnores(git bool) *listIgnores {
+	return &listIgnores{git: git, matchers: map[string]*ignoreMatcher{}}
+}
+
+func (l *listIgnores) ignored(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir := filepath.Dir(abs)
+	top := filepath.VolumeName(abs) + string(filepath.Separator)
+	m, ok := l.matchers[dir]
+	if !ok {
+		m = newIgnoreMatcher(ignoreFile)
+		if l.git {
+			m = newGitIgnoreMatcher(dir)
+		}
+		// the images of a work tree share the cached rules
+		for _, other := range l.matchers {
+			if other.top == m.top {
+				m = other
+				break
+			}
+		}
+		l.matchers[dir] = m
+	}
+	if m.top != "" {
+		top = m.top
+	}
+	// the walk skips an ignored folder, here its images are checked one by one
+	rel, err := filepath.Rel(top, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	folder := top
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			folder = filepath.Join(folder, part)
+			if m.ignored(top, folder, true) {
+				return true
+			}
+		}
+	}
+	return m.ignored(top, abs, false)
+}
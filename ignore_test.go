@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the expectations are those of git check-ignore
+func TestIgnoreRules(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.jpg", "a.jpg", false, true},
+		{"*.jpg", "foo/bar/a.jpg", false, true},
+		{"*.jpg", "a.png", false, false},
+		{"c?jpg", "c.jpg", false, true},
+		{"c?jpg", "c/jpg", false, false},
+		{"bar", "foo/bar", true, true},
+		{"bar", "foo/bar", false, true},
+		{"bar/", "foo/bar", true, true},
+		{"bar/", "foo/bar", false, false},
+		{"/bar", "bar", false, true},
+		{"/bar", "foo/bar", false, false},
+		{"foo/bar", "foo/bar", false, true},
+		{"foo/bar", "x/foo/bar", false, false},
+		{"foo/*.jpg", "foo/a.jpg", false, true},
+		{"foo/*.jpg", "foo/bar/a.jpg", false, false},
+		{"foo/**", "foo", true, false},
+		{"foo/**", "foo/bar", true, true},
+		{"foo/**", "foo/bar/a.jpg", false, true},
+		{"**/bar", "bar", true, true},
+		{"**/bar", "foo/x/bar", true, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "x/a/b", false, false},
+		{"[!x].jpg", "x.jpg", false, false},
+		{"[!x].jpg", "y.jpg", false, true},
+		{"[^x].jpg", "y.jpg", false, true},
+		{`\[!x].jpg`, "[!x].jpg", false, true},
+		{"foo ", "foo", false, true},
+		{`foo\ `, "foo ", false, true},
+		{`foo\ `, "foo", false, false},
+		{"foo\t", "foo", false, false},
+		{`\#a`, "#a", false, true},
+		{`\!a`, "!a", false, true},
+		{"#a", "#a", false, false},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		err := os.WriteFile(filepath.Join(root, ignoreFile), []byte(tt.pattern+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := newIgnoreMatcher(ignoreFile)
+		got := m.ignored(root, filepath.Join(root, filepath.FromSlash(tt.path)), tt.isDir)
+		if got != tt.want {
+			t.Errorf("%q matches %q (folder %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreNested(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		ignoreFile:                "*.png\n!keep.png\n/top.jpg\n",
+		"sub/" + ignoreFile:       "top.jpg\n!*.png\n",
+		"sub/deep/" + ignoreFile:  "/b.jpg\n",
+		"other/" + ignoreFile:     "# only a comment\n\n",
+		"sub/deep/more/dummy.txt": "",
+	}
+	for name, data := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err == nil {
+			err = os.WriteFile(file, []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"a.png", true},
+		{"keep.png", false},
+		{"top.jpg", true},
+		{"other/top.jpg", false},
+		{"other/a.png", true},
+		{"sub/top.jpg", true},
+		{"sub/a.png", false},
+		{"sub/deep/b.jpg", true},
+		{"sub/deep/more/b.jpg", false},
+	}
+	m := newIgnoreMatcher(ignoreFile)
+	for _, tt := range tests {
+		got := m.ignored(root, filepath.Join(root, filepath.FromSlash(tt.path)), false)
+		if got != tt.want {
+			t.Errorf("%s ignored = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// keepAlive is sent with every request to Ollama (--keep-alive), nil leaves
+// it to the server (5 minutes or OLLAMA_KEEP_ALIVE)
+var keepAlive *api.Duration
+
+// parseKeepAlive accepts what Ollama accepts: a duration like 5m, seconds
+// like 300, 0 to unload the model after each request and -1 to keep it loaded
+func parseKeepAlive(s string) (*api.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return &api.Duration{Duration: -1}, nil
+		}
+		return &api.Duration{Duration: time.Duration(seconds) * time.Second}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --keep-alive %q, use a duration like 5m, 0 or -1", s)
+	}
+	return &api.Duration{Duration: d}, nil
+}
+
+// unloadModels frees the memory of the models on every Ollama host of the
+// pool, a generate request without prompt and a keep_alive of 0 unloads a model
+func unloadModels(pool *hostPool, models ...string) {
+	var unique []string
+	for _, model := range models {
+		if model != "" && !slices.Contains(unique, model) {
+			unique = append(unique, model)
+		}
+	}
+	for _, h := range pool.hosts {
+		if h.ollama == nil || h.down {
+			continue
+		}
+		for _, model := range unique {
+			req := &api.GenerateRequest{Model: model, KeepAlive: &api.Duration{}}
+			err := h.ollama.Generate(context.Background(), req, func(api.GenerateResponse) error { return nil })
+			if err != nil {
+				logError("Could not unload %s on %s: %v", model, h.url, err)
+				continue
+			}
+			logVerbose("Unloaded %s on %s", model, h.url)
+		}
+	}
+}
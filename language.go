@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageNames are used in the instruction for --language, other codes are used as given
+var languageNames = map[string]string{
+	"en": "English",
+	"de": "German",
+	"fr": "French",
+	"es": "Spanish",
+	"it": "Italian",
+	"nl": "Dutch",
+	"pt": "Portuguese",
+	"sv": "Swedish",
+	"da": "Danish",
+	"no": "Norwegian",
+	"pl": "Polish",
+	"cs": "Czech",
+	"tr": "Turkish",
+	"ru": "Russian",
+	"ja": "Japanese",
+	"zh": "Chinese",
+	"ko": "Korean",
+}
+
+// stopWords are frequent short words that are enough to tell the languages
+// apart that the models drift into. Languages without a list are never detected.
+var stopWords = map[string][]string{
+	"en": {"the", "a", "an", "and", "of", "with", "in", "is", "on", "are", "its", "their", "this", "from", "while"},
+	"de": {"der", "die", "das", "und", "ein", "eine", "einer", "einem", "einen", "mit", "ist", "auf", "im", "den", "dem", "sind", "von", "sich", "zu"},
+	"fr": {"le", "la", "les", "et", "un", "une", "des", "du", "avec", "est", "dans", "sur", "sont", "au", "aux"},
+	"es": {"el", "la", "los", "las", "y", "un", "una", "con", "es", "en", "del", "sobre", "están", "al"},
+	"it": {"il", "lo", "la", "gli", "le", "e", "un", "una", "con", "è", "di", "della", "nel", "sono", "sul"},
+	"nl": {"de", "het", "een", "en", "met", "is", "op", "zijn", "van", "in", "voor", "die"},
+	"pt": {"o", "a", "os", "as", "e", "um", "uma", "com", "é", "em", "do", "da", "no", "na", "são"},
+}
+
+func languageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+func languageHint(args args) string {
+	if args.Language == "" {
+		return ""
+	}
+	return "\nWrite the answer in " + languageName(args.Language) + " only, even though these instructions are in English."
+}
+
+// detectLanguage guesses the language of the text by counting the stop words,
+// it returns "" if the text is too short or the guess is not clear
+func detectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) < 4 {
+		return ""
+	}
+	scores := map[string]int{}
+	for _, word := range words {
+		for lang, list := range stopWords {
+			if contains(list, word) {
+				scores[lang]++
+			}
+		}
+	}
+	best := ""
+	for lang, score := range scores {
+		if best == "" || score > scores[best] {
+			best = lang
+		}
+	}
+	if best == "" || scores[best] < 2 {
+		return ""
+	}
+	for lang, score := range scores {
+		if lang != best && score == scores[best] {
+			return ""
+		}
+	}
+	return best
+}
+
+// wrongLanguage checks if the answer is clearly not in the language of --language
+func wrongLanguage(args args, text string) (string, bool) {
+	if args.Language == "" {
+		return "", false
+	}
+	detected := detectLanguage(text)
+	return detected, detected != "" && detected != args.Language
+}
+
+// askInLanguage asks the prompt and checks the language of the answer. An
+// answer in the wrong language is asked again once and then translated with
+// --translate-model.
+func askInLanguage(ol *hostPool, args args, usage *tokenUsage, prompt string, images ...[]byte) (string, error) {
+	answer, err := CaptionImage(ol, args, usage, prompt, "", images...)
+	if err != nil {
+		return "", err
+	}
+	detected, wrong := wrongLanguage(args, answer)
+	if !wrong {
+		return answer, nil
+	}
+	logVerbose("The answer is in %s instead of %s, asking again", languageName(detected), languageName(args.Language))
+	retry := prompt + "\nYour last answer was in " + languageName(detected) + ". Answer in " + languageName(args.Language) + "!"
+	answer, err = CaptionImage(ol, args, usage, retry, "", images...)
+	if err != nil {
+		return "", err
+	}
+	return translateAnswer(ol, args, usage, answer)
+}
+
+// translateAnswer translates the text with the text model of --translate-model
+// if it is not in the language of --language
+func translateAnswer(ol *hostPool, args args, usage *tokenUsage, text string) (string, error) {
+	detected, wrong := wrongLanguage(args, text)
+	if !wrong {
+		return text, nil
+	}
+	if args.TranslateModel == "" {
+		logInfo("The answer is in %s instead of %s (use --translate-model to translate it)", languageName(detected), languageName(args.Language))
+		return text, nil
+	}
+	logVerbose("Translating the answer from %s into %s with %s", languageName(detected), languageName(args.Language), args.TranslateModel)
+	translator := args
+	translator.Model = args.TranslateModel
+	translator.System = ""
+	translator.ForceOneSentence = false
+	translator.history = nil
+	prompt := "Translate the following text into " + languageName(args.Language) + ". Answer only with the translation.\n\n" + text
+	return CaptionImage(ol, translator, usage, prompt, "")
+}
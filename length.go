@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// tooLong checks the answer against --max-chars, --max-words and --clip-budget
+func tooLong(args args, text string) bool {
+	return (args.MaxChars > 0 && utf8.RuneCountInString(text) > args.MaxChars) ||
+		(args.MaxWords > 0 && len(strings.Fields(text)) > args.MaxWords) ||
+		(args.ClipBudget > 0 && clip.count(text) > answerClipBudget(args))
+}
+
+// answerClipBudget is what is left of --clip-budget for the answer after the
+// trigger word, --start and --end
+func answerClipBudget(args args) int {
+	return args.ClipBudget - clip.count(args.Trigger+", "+args.StartCaption+" "+args.EndCaption)
+}
+
+// lengthLimit describes the limits for the model, which can't count CLIP
+// tokens, so the words are scaled down by the tokens that are too many
+func lengthLimit(args args, text string) string {
+	var limits []string
+	if args.ClipBudget > 0 {
+		if tokens := clip.count(text); tokens > answerClipBudget(args) {
+			words := len(strings.Fields(text)) * answerClipBudget(args) / tokens
+			limits = append(limits, fmt.Sprintf("%d words", max(words, 1)))
+		}
+	}
+	if args.MaxWords > 0 {
+		limits = append(limits, fmt.Sprintf("%d words", args.MaxWords))
+	}
+	if args.MaxChars > 0 {
+		limits = append(limits, fmt.Sprintf("%d characters", args.MaxChars))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// shortenAnswer asks the model to shorten its own answer until it fits the
+// limits, instead of cutting it off. After --shorten-attempts the last answer
+// is kept as it is.
+func shortenAnswer(ol *hostPool, args args, usage *tokenUsage, text string) (string, error) {
+	if !tooLong(args, text) {
+		return text, nil
+	}
+	shortener := args
+	shortener.System = ""
+	shortener.ForceOneSentence = false
+	for attempt := 1; attempt <= args.ShortenAttempts; attempt++ {
+		limit := lengthLimit(args, text)
+		logVerbose("The answer has %d characters and %d words, asking to shorten it to %s (attempt %d)",
+			utf8.RuneCountInString(text), len(strings.Fields(text)), limit, attempt)
+		prompt := "Shorten this image caption to at most " + limit + ". Keep the most important details, the style and the language. Answer only with the shortened caption.\n\n" + text
+		answer, err := CaptionImage(ol, shortener, usage, prompt, "")
+		if err != nil {
+			return "", err
+		}
+		text = strings.TrimSpace(answer)
+		if !tooLong(args, text) {
+			return text, nil
+		}
+	}
+	logInfo("The answer is still longer than %s after %d attempts", lengthLimit(args, text), args.ShortenAttempts)
+	return text, nil
+}
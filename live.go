@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// The live output settings can be toggled while running (see watchSignals)
+var (
+	liveVerbose atomic.Bool
+	liveStream  atomic.Bool
+)
+
+// toggle flips the setting and returns its new state
+func toggle(setting *atomic.Bool) bool {
+	on := !setting.Load()
+	setting.Store(on)
+	return on
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// streamLane is the image whose tokens are shown, with several --workers the
+// tokens of the other images are not shown until the request of this one ends
+var streamLane struct {
+	sync.Mutex
+	image   string
+	owned   bool
+	pending []string // results printed while the lane was owned
+}
+
+// streamToken shows the tokens to stderr as they arrive if streaming is on
+func streamToken(ctx context.Context, token string) {
+	if !liveStream.Load() {
+		return
+	}
+	image := contextImage(ctx)
+	streamLane.Lock()
+	defer streamLane.Unlock()
+	if !streamLane.owned {
+		streamLane.image, streamLane.owned = image, true
+		if image != "" {
+			fmt.Fprintf(os.Stderr, "== %s\n", image)
+		}
+	}
+	if streamLane.image == image {
+		fmt.Fprint(os.Stderr, token)
+	}
+}
+
+// endStream frees the lane when the request of its image is done or failed
+func endStream(ctx context.Context) {
+	streamLane.Lock()
+	defer streamLane.Unlock()
+	if streamLane.owned && streamLane.image == contextImage(ctx) {
+		fmt.Fprintln(os.Stderr)
+		streamLane.image, streamLane.owned = "", false
+		for _, text := range streamLane.pending {
+			fmt.Print(text)
+		}
+		streamLane.pending = nil
+	}
+}
+
+// printBetweenStreams prints a result to stdout, while tokens are streamed it
+// waits for the end of the line so the result doesn't end up in the middle
+func printBetweenStreams(text string) {
+	streamLane.Lock()
+	defer streamLane.Unlock()
+	if streamLane.owned {
+		streamLane.pending = append(streamLane.pending, text)
+		return
+	}
+	fmt.Print(text)
+}
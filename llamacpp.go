@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// llamaCppTemplate is the llava style prompt for the /completion endpoint,
+// {system}, {images} and {prompt} are replaced
+const llamaCppTemplate = "{system}\nUSER:{images}\n{prompt}\nASSISTANT:"
+
+// llamaCppClient talks to the native /completion endpoint of llama.cpp's
+// llama-server, which handles the images with its multimodal projector
+type llamaCppClient struct {
+	endpoint string
+	template string
+	http     *http.Client
+}
+
+// newLlamaCppClient uses the template of --llamacpp-template, where \n can be used for newlines
+func newLlamaCppClient(base *url.URL, template string) *llamaCppClient {
+	if template == "" {
+		template = llamaCppTemplate
+	}
+	template = strings.ReplaceAll(template, `\n`, "\n")
+	return &llamaCppClient{endpoint: base.JoinPath("completion").String(), template: template, http: backendClient}
+}
+
+type llamaCppImage struct {
+	Data string `json:"data"`
+	ID   int    `json:"id"`
+}
+
+type llamaCppRequest struct {
+	Prompt        string          `json:"prompt"`
+	ImageData     []llamaCppImage `json:"image_data,omitempty"`
+	NPredict      *int            `json:"n_predict,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	TopK          *int            `json:"top_k,omitempty"`
+	MinP          *float64        `json:"min_p,omitempty"`
+	RepeatPenalty *float64        `json:"repeat_penalty,omitempty"`
+	Mirostat      *int            `json:"mirostat,omitempty"`
+	MirostatTau   *float64        `json:"mirostat_tau,omitempty"`
+	MirostatEta   *float64        `json:"mirostat_eta,omitempty"`
+	Seed          *int            `json:"seed,omitempty"`
+	Stop          []string        `json:"stop,omitempty"`
+	JSONSchema    map[string]any  `json:"json_schema,omitempty"`
+	CachePrompt   bool            `json:"cache_prompt"`
+}
+
+// MarshalJSON adds the --extra-body fields
+func (r llamaCppRequest) MarshalJSON() ([]byte, error) {
+	type plain llamaCppRequest
+	return withExtraBody(plain(r))
+}
+
+type llamaCppResponse struct {
+	Content         string `json:"content"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+	TokensPredicted int    `json:"tokens_predicted"`
+	Error           *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *llamaCppClient) Generate(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+	answer, metrics, err := c.complete(ctx, req.System, req.Prompt, req.Images, req.Options, req.Format)
+	if err != nil {
+		return err
+	}
+	return fn(api.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Response: answer, Done: true, Metrics: metrics})
+}
+
+// Chat sends the last message with the images of all messages, llama-server
+// can't apply the chat template of the model on the /completion endpoint
+func (c *llamaCppClient) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	if len(req.Messages) == 0 {
+		return fmt.Errorf("no messages")
+	}
+	var system []string
+	var images []api.ImageData
+	for _, msg := range req.Messages[:len(req.Messages)-1] {
+		system = append(system, msg.Content)
+		images = append(images, msg.Images...)
+	}
+	last := req.Messages[len(req.Messages)-1]
+	answer, metrics, err := c.complete(ctx, strings.Join(system, "\n"), last.Content, append(images, last.Images...), req.Options, req.Format)
+	if err != nil {
+		return err
+	}
+	return fn(api.ChatResponse{Model: req.Model, CreatedAt: time.Now(), Message: api.Message{Role: "assistant", Content: answer}, Done: true, Metrics: metrics})
+}
+
+func (c *llamaCppClient) complete(ctx context.Context, system string, prompt string, images []api.ImageData, options map[string]any, format string) (string, api.Metrics, error) {
+	var metrics api.Metrics
+	body := llamaCppRequest{CachePrompt: true}
+	// the images are referenced by their id in the prompt
+	var refs strings.Builder
+	for i, img := range images {
+		id := 10 + i
+		body.ImageData = append(body.ImageData, llamaCppImage{Data: base64.StdEncoding.EncodeToString(img), ID: id})
+		fmt.Fprintf(&refs, "[img-%d]", id)
+	}
+	body.Prompt = strings.NewReplacer("{system}", system, "{images}", refs.String(), "{prompt}", prompt).Replace(c.template)
+	body.Prompt = strings.TrimLeft(body.Prompt, "\n")
+
+	if v, ok := options["num_predict"].(int); ok {
+		body.NPredict = &v
+	}
+	// the sampling options have the same names in llama.cpp
+	for name, field := range map[string]**float64{"temperature": &body.Temperature, "top_p": &body.TopP, "min_p": &body.MinP, "repeat_penalty": &body.RepeatPenalty, "mirostat_tau": &body.MirostatTau, "mirostat_eta": &body.MirostatEta} {
+		if v, ok := floatOption(options, name); ok {
+			*field = &v
+		}
+	}
+	for name, field := range map[string]**int{"top_k": &body.TopK, "mirostat": &body.Mirostat} {
+		if v, ok := options[name].(int); ok {
+			*field = &v
+		}
+	}
+	if v, ok := options["seed"].(int); ok {
+		body.Seed = &v
+	}
+	if v, ok := options["stop"].([]string); ok {
+		body.Stop = v
+	}
+	if format == "json" {
+		body.JSONSchema = map[string]any{"type": "object"}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", metrics, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", metrics, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", metrics, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", metrics, err
+	}
+
+	var answer llamaCppResponse
+	err = json.Unmarshal(raw, &answer)
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(raw))
+		if err == nil && answer.Error != nil {
+			msg = answer.Error.Message
+		}
+		return "", metrics, api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, ErrorMessage: msg}
+	}
+	if err != nil {
+		return "", metrics, fmt.Errorf("unmarshal: %w", err)
+	}
+	metrics.PromptEvalCount = answer.TokensEvaluated
+	metrics.EvalCount = answer.TokensPredicted
+	return answer.Content, metrics, nil
+}
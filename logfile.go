@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile is the --log-file. It is renamed to FILE.YYYYMMDD-HHMMSS when it
+// grows over --log-max-size or is older than --log-rotate, and only the
+// newest --log-keep of the renamed files are kept.
+type rotatingFile struct {
+	path    string
+	maxSize int64         // 0 for no limit
+	maxAge  time.Duration // 0 for no limit
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+var logFile *rotatingFile // nil without --log-file
+
+func openLogFile(path string, maxSizeMB int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSizeMB * 1024 * 1024, maxAge: maxAge, keep: keep}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open appends to the file, its age starts with the first line written to it
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	if r.size > 0 {
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize || r.maxAge > 0 && time.Since(r.opened) >= r.maxAge) {
+		err := r.rotate()
+		if err != nil {
+			// the line is not lost, the file just grows
+			fmt.Fprintf(os.Stderr, "Could not rotate log file: %v\n", err)
+		}
+	}
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and removes the oldest rotated files, the caller holds the lock
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	rotated := r.path + "." + time.Now().Format("20060102-150405")
+	err := os.Rename(r.path, rotated)
+	if openErr := r.open(); err == nil {
+		err = openErr
+	}
+	if err != nil {
+		return err
+	}
+	old, _ := filepath.Glob(r.path + ".*-*")
+	sort.Strings(old)
+	for len(old) > r.keep {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+	return nil
+}
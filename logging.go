@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logLevel controls which messages are written to stderr
+type logLevel int32
+
+const (
+	levelError logLevel = iota
+	levelInfo
+	levelVerbose
+	levelDebug
+)
+
+var levelNames = map[logLevel]string{levelError: "error", levelInfo: "info", levelVerbose: "verbose", levelDebug: "debug"}
+
+var currentLevel atomic.Int32
+
+// logJSON is set by --log-format json, every log line is a JSON object then
+var logJSON bool
+
+// logPath marks the argument of a log message that is the image, with
+// --log-format json it is the path field of the line
+type logPath string
+
+// logEntry is a line of --log-format json
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Path    string    `json:"path,omitempty"`
+	Message string    `json:"msg"`
+	*result           // the caption of a per-image result
+}
+
+var logMu sync.Mutex
+
+// logOutput is stderr, with --log-file the file too
+var logOutput io.Writer = os.Stderr
+
+// fileLog writes the results of the text format to the --log-file
+var fileLog *log.Logger
+
+// setLogFile opens the --log-file
+func setLogFile(args args) error {
+	if args.LogFile == "" {
+		return nil
+	}
+	var err error
+	logFile, err = openLogFile(args.LogFile, args.LogMaxSize, args.LogRotate, args.LogKeep)
+	if err != nil {
+		return err
+	}
+	logOutput = io.MultiWriter(os.Stderr, logFile)
+	log.SetOutput(logOutput)
+	fileLog = log.New(logFile, "", log.LstdFlags)
+	return nil
+}
+
+func writeLogEntry(entry logEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// can't happen for strings and the result struct
+		panic(err)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput.Write(append(data, '\n'))
+}
+
+// jsonLogWriter turns the lines of the log package (log.Fatalf) into error entries
+type jsonLogWriter struct{}
+
+func (jsonLogWriter) Write(p []byte) (int, error) {
+	writeLogEntry(logEntry{Time: time.Now(), Level: levelNames[levelError], Message: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+// setLogFormat switches to JSON lines, the progress bar is not drawn then
+func setLogFormat(format string) {
+	logJSON = format == "json"
+	if logJSON {
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{})
+	}
+}
+
+// logMessage writes the message with the prefix of the log package or as JSON
+func logMessage(level logLevel, format string, v ...any) {
+	if !logJSON {
+		log.Printf(format, v...)
+		return
+	}
+	entry := logEntry{Time: time.Now(), Level: levelNames[level], Message: fmt.Sprintf(format, v...)}
+	for _, arg := range v {
+		if path, ok := arg.(logPath); ok {
+			entry.Path = string(path)
+			break
+		}
+	}
+	writeLogEntry(entry)
+}
+
+func init() {
+	currentLevel.Store(int32(levelInfo))
+}
+
+// setLogLevel sets the level from the --quiet, --verbose and --debug flags
+func setLogLevel(args args) {
+	level := levelInfo
+	switch {
+	case args.Debug:
+		level = levelDebug
+	case args.Verbose:
+		level = levelVerbose
+	case args.Quiet:
+		level = levelError
+	}
+	currentLevel.Store(int32(level))
+}
+
+// logEnabled checks the level, verbose output can also be switched on at runtime
+func logEnabled(level logLevel) bool {
+	if level <= logLevel(currentLevel.Load()) {
+		return true
+	}
+	return level == levelVerbose && liveVerbose.Load()
+}
+
+func logError(format string, v ...any) {
+	logMessage(levelError, format, v...)
+}
+
+func logInfo(format string, v ...any) {
+	if logEnabled(levelInfo) {
+		logMessage(levelInfo, format, v...)
+	}
+}
+
+func logVerbose(format string, v ...any) {
+	if logEnabled(levelVerbose) {
+		logMessage(levelVerbose, format, v...)
+	}
+}
+
+func logDebug(format string, v ...any) {
+	if logEnabled(levelDebug) {
+		logMessage(levelDebug, format, v...)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// manifest is a JSON lines file with the captions of images that have no
+// folder for caption files: the entries of archives and URLs. The lines are
+// appended as the images are captioned, so an interrupted run is resumed.
+type manifest struct {
+	mu       sync.Mutex
+	captions map[string]string // by path or URL
+	out      *os.File          // nil with --dry-run
+	enc      *json.Encoder
+}
+
+// manifestEntry is a line of the manifest
+type manifestEntry struct {
+	Path    string `json:"path,omitempty"` // name of the entry in the archive
+	URL     string `json:"url,omitempty"`
+	Caption string `json:"caption"`
+}
+
+func (e manifestEntry) key() string {
+	if e.URL != "" {
+		return e.URL
+	}
+	return e.Path
+}
+
+// manifestFile is --manifest or the default: next to the archive or the
+// list of --urls, captions.jsonl for a single URL or a list from stdin
+func manifestFile(args args) string {
+	switch {
+	case args.Manifest != "":
+		return args.Manifest
+	case archiveExt(args.Path) != "":
+		return args.Path[:len(args.Path)-len(archiveExt(args.Path))] + ".captions.jsonl"
+	case args.URLs != "" && args.URLs != "-":
+		return strings.TrimSuffix(args.URLs, filepath.Ext(args.URLs)) + ".captions.jsonl"
+	}
+	return "captions.jsonl"
+}
+
+// openManifest reads the captions of the manifest (a missing one has none)
+// and opens it for appending the new captions
+func openManifest(file string, dryRun bool) (*manifest, error) {
+	m := &manifest{captions: map[string]string{}}
+	f, err := os.Open(file)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var e manifestEntry
+			err = json.Unmarshal(scanner.Bytes(), &e)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("invalid manifest %s: %w", file, err)
+			}
+			// a caption of --force replaces the earlier one
+			m.captions[e.key()] = e.Caption
+		}
+		f.Close()
+		if scanner.Err() != nil {
+			return nil, scanner.Err()
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if dryRun {
+		return m, nil
+	}
+	m.out, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.enc = json.NewEncoder(m.out)
+	return m, nil
+}
+
+// has checks if the manifest has a caption for the path or URL
+func (m *manifest) has(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.captions[key]
+	return ok
+}
+
+// add appends the caption to the manifest
+func (m *manifest) add(e manifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captions[e.key()] = e.Caption
+	if m.out == nil {
+		return nil
+	}
+	err := m.enc.Encode(e)
+	if err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
+
+func (m *manifest) Close() error {
+	if m.out == nil {
+		return nil
+	}
+	return m.out.Close()
+}
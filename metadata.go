@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const countsPrompt = "Count the people, animals and vehicles that are visible in this image. Answer only with JSON in the form {\"people\": 0, \"animals\": 0, \"vehicles\": 0}."
+
+// objectCounts are the numbers of people, animals and vehicles visible in an image
+type objectCounts struct {
+	People   int `json:"people"`
+	Animals  int `json:"animals"`
+	Vehicles int `json:"vehicles"`
+}
+
+func (c objectCounts) String() string {
+	return fmt.Sprintf("people: %d, animals: %d, vehicles: %d", c.People, c.Animals, c.Vehicles)
+}
+
+// imageMetadata holds the structured fields that are written to the .json file of an image
+type imageMetadata struct {
+	Caption string        `json:"caption"`
+	Counts  *objectCounts `json:"counts,omitempty"`
+	Rating  string        `json:"rating,omitempty"`
+}
+
+// CountObjects asks the model for the number of people, animals and vehicles in the image
+func CountObjects(ol *hostPool, args args, usage *tokenUsage, imgData []byte) (*objectCounts, error) {
+	answer, err := CaptionImage(ol, args, usage, countsPrompt, "json", imgData)
+	if err != nil {
+		return nil, err
+	}
+	var counts objectCounts
+	err = json.Unmarshal([]byte(answer), &counts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid counts answer %q: %w", answer, err)
+	}
+	counts.People = max(counts.People, 0)
+	counts.Animals = max(counts.Animals, 0)
+	counts.Vehicles = max(counts.Vehicles, 0)
+	return &counts, nil
+}
+
+// metadataFile returns the name of the .json file that belongs to the image
+func metadataFile(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ".json"
+}
+
+func writeMetadata(imagePath string, meta imageMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataFile(imagePath), append(data, '\n'), 0644)
+}
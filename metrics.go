@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram in
+// seconds, vision models take seconds to minutes per image
+var latencyBuckets = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300}
+
+// histogram counts the requests of a backend by latency
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+	errors uint64
+}
+
+// requestMetrics are the latencies of the requests by backend for /metrics,
+// the counters of the images come from the run statistics
+type requestMetrics struct {
+	mu       sync.Mutex
+	backends map[string]*histogram
+	queue    int // images of the last scan that wait for their caption
+}
+
+var metrics = &requestMetrics{backends: map[string]*histogram{}}
+
+// observe records a request to the backend
+func (m *requestMetrics) observe(backend string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.backends[backend]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.backends[backend] = h
+	}
+	if err != nil {
+		h.errors++
+		return
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// queued sets the number of images that wait for their caption
+func (m *requestMetrics) queued(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = n
+}
+
+// dequeue counts down the queue when an image is done
+func (m *requestMetrics) dequeue() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = max(m.queue-1, 0)
+}
+
+// serveMetrics writes the metrics in the Prometheus text format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metric := func(name string, kind string, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	stats.mu.Lock()
+	metric("capollama_images_processed_total", "counter", "Images that were captioned.")
+	fmt.Fprintf(&b, "capollama_images_processed_total %d\n", stats.Processed)
+	metric("capollama_images_failed_total", "counter", "Images that could not be captioned.")
+	fmt.Fprintf(&b, "capollama_images_failed_total %d\n", stats.Failed)
+	metric("capollama_images_corrupt_total", "counter", "Images that could not be decoded.")
+	fmt.Fprintf(&b, "capollama_images_corrupt_total %d\n", len(stats.Corrupt))
+	metric("capollama_images_skipped", "gauge", "Images that were skipped by the last scan.")
+	fmt.Fprintf(&b, "capollama_images_skipped{reason=\"existing\"} %d\n", stats.SkippedExisting)
+	fmt.Fprintf(&b, "capollama_images_skipped{reason=\"imported\"} %d\n", stats.SkippedImported)
+	fmt.Fprintf(&b, "capollama_images_skipped{reason=\"poisoned\"} %d\n", stats.SkippedPoisoned)
+	metric("capollama_tokens_total", "counter", "Tokens used by the model.")
+	fmt.Fprintf(&b, "capollama_tokens_total{kind=\"prompt\"} %d\n", stats.PromptTokens)
+	fmt.Fprintf(&b, "capollama_tokens_total{kind=\"completion\"} %d\n", stats.CompletionTokens)
+	stats.mu.Unlock()
+
+	metrics.mu.Lock()
+	metric("capollama_queue_depth", "gauge", "Images of the last scan that wait for their caption.")
+	fmt.Fprintf(&b, "capollama_queue_depth %d\n", metrics.queue)
+	backends := make([]string, 0, len(metrics.backends))
+	for backend := range metrics.backends {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	metric("capollama_request_duration_seconds", "histogram", "Latency of the successful requests by backend.")
+	for _, backend := range backends {
+		h := metrics.backends[backend]
+		label := escapeLabel(backend)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "capollama_request_duration_seconds_bucket{backend=\"%s\",le=\"%g\"} %d\n", label, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "capollama_request_duration_seconds_bucket{backend=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "capollama_request_duration_seconds_sum{backend=\"%s\"} %g\n", label, h.sum)
+		fmt.Fprintf(&b, "capollama_request_duration_seconds_count{backend=\"%s\"} %d\n", label, h.count)
+	}
+	metric("capollama_request_errors_total", "counter", "Failed requests by backend.")
+	for _, backend := range backends {
+		fmt.Fprintf(&b, "capollama_request_errors_total{backend=\"%s\"} %d\n", escapeLabel(backend), metrics.backends[backend].errors)
+	}
+	metrics.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// startMetrics serves /metrics on the address in the background
+func startMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	logInfo("Serving metrics on http://%s/metrics", addr)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logError("Could not serve metrics: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ollama/ollama/api"
+)
+
+// the OpenAI models that accept images, matched by prefix
+var openAIVisionModels = []string{"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-4-vision", "gpt-5", "o1", "o3", "o4"}
+
+type modelsArgs struct {
+	Hosts     []string `arg:"--host,separate" help:"Ollama host (host:port or URL) to query instead of CAPOLLAMA_HOST or OLLAMA_HOST, can be repeated"`
+	OpenAI    bool     `arg:"--openai" help:"List the models of the OpenAI API (the key is read from OPENAI_API_KEY) instead of Ollama"`
+	OpenAIURL string   `arg:"--openai-url" help:"Base URL of the OpenAI API" default:"https://api.openai.com/v1"`
+	Vision    bool     `arg:"--vision" help:"Only list the models that support images"`
+	Format    string   `arg:"--format" help:"Output format: text or json" default:"text"`
+}
+
+func (modelsArgs) Description() string {
+	return "Lists the models of the backend and shows which support images and which are loaded\n"
+}
+
+// modelInfo is a line of the models list
+type modelInfo struct {
+	Host   string `json:"host"`
+	Name   string `json:"name"`
+	Vision *bool  `json:"vision"` // nil if unknown
+	Loaded *bool  `json:"loaded"` // nil if the backend doesn't tell
+	Size   int64  `json:"size,omitempty"`
+}
+
+func runModels(cmdline []string) {
+	var ma modelsArgs
+	p := newParser(appName+" models", &ma)
+	p.MustParse(cmdline)
+	if ma.Format != "text" && ma.Format != "json" {
+		p.Fail(fmt.Sprintf("unknown format %q", ma.Format))
+	}
+
+	var models []modelInfo
+	var err error
+	if ma.OpenAI {
+		models, err = openAIModels(ma.OpenAIURL)
+	} else {
+		models, err = ollamaModels(defaultHosts(ma.Hosts))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitBackend)
+	}
+	if ma.Vision {
+		var vision []modelInfo
+		for _, m := range models {
+			if m.Vision != nil && *m.Vision {
+				vision = append(vision, m)
+			}
+		}
+		models = vision
+	}
+
+	if ma.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, m := range models {
+			err = enc.Encode(m)
+			if err != nil {
+				log.Fatalf("Could not write: %v", err)
+			}
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVISION\tLOADED\tHOST")
+	for _, m := range models {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, yesNo(m.Vision), yesNo(m.Loaded), m.Host)
+	}
+	w.Flush()
+}
+
+func yesNo(v *bool) string {
+	switch {
+	case v == nil:
+		return "?"
+	case *v:
+		return "yes"
+	}
+	return "no"
+}
+
+func ollamaModels(hosts []string) ([]modelInfo, error) {
+	pool, err := newHostPool(hosts, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	var models []modelInfo
+	for _, h := range pool.hosts {
+		list, err := h.ollama.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not list the models of %s: %w", h.url, err)
+		}
+		running, err := h.ollama.ListRunning(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not list the running models of %s: %w", h.url, err)
+		}
+		loaded := map[string]bool{}
+		for _, m := range running.Models {
+			loaded[normalizeModel(m.Name)] = true
+		}
+		for _, m := range list.Models {
+			vision := hasVision(m.Details)
+			if !vision {
+				show, err := h.ollama.Show(ctx, &api.ShowRequest{Model: m.Name})
+				vision = err == nil && len(show.ProjectorInfo) > 0
+			}
+			isLoaded := loaded[normalizeModel(m.Name)]
+			models = append(models, modelInfo{Host: h.url.String(), Name: m.Name, Vision: &vision, Loaded: &isLoaded, Size: m.Size})
+		}
+	}
+	sort.SliceStable(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return models, nil
+}
+
+// openAIModels lists the models of the OpenAI API, which doesn't report the
+// capabilities, so vision is guessed from the name
+func openAIModels(base string) ([]modelInfo, error) {
+	key := os.Getenv(openAIKeyEnv)
+	if key == "" {
+		return nil, fmt.Errorf("the API key must be set in %s", openAIKeyEnv)
+	}
+	c := &batchClient{base: strings.TrimRight(base, "/"), key: key, http: http.DefaultClient}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	err := c.do(http.MethodGet, "/models", "", nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	var models []modelInfo
+	for _, m := range list.Data {
+		vision := false
+		for _, prefix := range openAIVisionModels {
+			if strings.HasPrefix(m.ID, prefix) && !strings.Contains(m.ID, "audio") && !strings.Contains(m.ID, "realtime") {
+				vision = true
+			}
+		}
+		models = append(models, modelInfo{Host: c.base, Name: m.ID, Vision: &vision})
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return models, nil
+}
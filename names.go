@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// namesFile is the TOML file of --names
+type namesFile struct {
+	People []struct {
+		Match string   `toml:"match"`
+		Names []string `toml:"names"`
+	} `toml:"people"`
+}
+
+type nameRule struct {
+	match string
+	names []string
+}
+
+// nameMap gives the model the names of the people in the images of a folder or file
+type nameMap []nameRule
+
+var names nameMap
+
+func loadNames(file string) (nameMap, error) {
+	var f namesFile
+	md, err := toml.DecodeFile(file, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid names %s: %w", file, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown setting %q in %s", undecoded[0].String(), file)
+	}
+	var m nameMap
+	for _, entry := range f.People {
+		match := strings.ToLower(filepath.ToSlash(strings.Trim(entry.Match, "/")))
+		if _, err := filepath.Match(match, ""); err != nil || match == "" {
+			return nil, fmt.Errorf("invalid match %q in %s", entry.Match, file)
+		}
+		m = append(m, nameRule{match: match, names: entry.Names})
+	}
+	return m, nil
+}
+
+// match returns the names of all entries that match the image. A pattern
+// without a slash is matched against the file name and the folder names, one
+// with a slash against the path below the root and its parent folders.
+func (m nameMap) match(path string, root string) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	rel := path
+	if root != "" {
+		if r, err := filepath.Rel(root, path); err == nil {
+			rel = r
+		}
+	}
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(rel)), "/")
+	var found []string
+	for _, rule := range m {
+		matched := false
+		for i := range parts {
+			candidate := parts[i]
+			if strings.Contains(rule.match, "/") {
+				candidate = strings.Join(parts[:i+1], "/")
+			}
+			if ok, _ := filepath.Match(rule.match, candidate); ok {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			for _, name := range rule.names {
+				if !contains(found, name) {
+					found = append(found, name)
+				}
+			}
+		}
+	}
+	return found
+}
+
+// peopleHint asks the model to use the names instead of "a woman" and "a man"
+func peopleHint(people []string) string {
+	switch len(people) {
+	case 0:
+		return ""
+	case 1:
+		return "\nThe person in this image is probably " + people[0] + ". Call them by their name instead of describing them as \"a woman\" or \"a man\"."
+	}
+	list := strings.Join(people[:len(people)-1], ", ") + " and " + people[len(people)-1]
+	return "\nThe people in this image are probably some of " + list + ". Call them by their names instead of describing them as \"a woman\" or \"a man\" " +
+		"when you can tell who is who, and don't name anyone who is not in the image."
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// objectStore is a bucket (or container) of a cloud storage. Like the
+// backends it talks to the REST APIs directly instead of pulling in the SDKs.
+type objectStore interface {
+	list(prefix string) ([]string, error)
+	get(key string) ([]byte, error)
+	put(key string, data []byte) error
+	url(key string) string // s3://bucket/key for logs and manifests
+}
+
+var storeSchemes = []string{"s3://", "gs://", "az://", "dav://", "davs://"}
+
+// isStoreURL checks if the path is an object storage URL
+func isStoreURL(path string) bool {
+	for _, scheme := range storeSchemes {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// openStore opens s3://bucket/prefix, gs://bucket/prefix,
+// az://account/container/prefix or the WebDAV folder davs://host/path and
+// returns the store with the prefix
+func openStore(path string) (objectStore, string, error) {
+	scheme, rest, _ := strings.Cut(path, "://")
+	if scheme == "dav" || scheme == "davs" {
+		store, err := newWebDAVStore(scheme, rest)
+		return store, "", err
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("no bucket in %q", path)
+	}
+	switch scheme {
+	case "s3":
+		store, err := newS3Store(bucket)
+		return store, prefix, err
+	case "gs":
+		store, err := newGCSStore(bucket)
+		return store, prefix, err
+	default:
+		container, prefix, _ := strings.Cut(prefix, "/")
+		if container == "" {
+			return nil, "", fmt.Errorf("no container in %q", path)
+		}
+		store, err := newAzureBlobStore(bucket, container)
+		return store, prefix, err
+	}
+}
+
+// storeRequest sends the request and returns the body of a 2xx response
+func storeRequest(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// s3Store uses the S3 REST API with AWS signature version 4. The credentials
+// and the region are read from the usual AWS_* variables, AWS_ENDPOINT_URL
+// selects an S3 compatible service (MinIO, R2) with path style URLs.
+type s3Store struct {
+	bucket   string
+	base     *url.URL // the bucket
+	region   string
+	key      string
+	secret   string
+	token    string
+	now      func() time.Time
+	hostOnly bool // virtual hosted style, the key is the whole path
+}
+
+func newS3Store(bucket string) (*s3Store, error) {
+	s := &s3Store{
+		bucket: bucket,
+		region: cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		key:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+		now:    time.Now,
+	}
+	if s.key == "" || s.secret == "" {
+		return nil, fmt.Errorf("the credentials must be set in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	var err error
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		s.base, err = url.Parse(strings.TrimRight(endpoint, "/") + "/" + bucket)
+	} else {
+		s.base, err = url.Parse("https://" + bucket + ".s3." + s.region + ".amazonaws.com")
+		s.hostOnly = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %w", err)
+	}
+	return s, nil
+}
+
+func (s *s3Store) url(key string) string {
+	return "s3://" + s.bucket + "/" + key
+}
+
+// request creates a signed request for the key ("" for the bucket)
+func (s *s3Store) request(method string, key string, query url.Values, body []byte) (*http.Request, error) {
+	path := strings.TrimRight(s.base.EscapedPath(), "/")
+	if key != "" || s.hostOnly {
+		path += "/" + awsEscape(key, false)
+	}
+	u := s.base.Scheme + "://" + s.base.Host + path
+	if len(query) > 0 {
+		u += "?" + awsQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, path, body)
+	return req, nil
+}
+
+// sign adds the headers of AWS signature version 4 to the request
+func (s *s3Store) sign(req *http.Request, path string, body []byte) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	req.Header.Set("x-amz-date", now.Format("20060102T150405Z"))
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payload[:]))
+	if s.token != "" {
+		req.Header.Set("x-amz-security-token", s.token)
+	}
+	names := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers.String(), signed, hex.EncodeToString(payload[:])}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{date, s.region, "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.key+"/"+scope+", SignedHeaders="+signed+", Signature="+hex.EncodeToString(key))
+}
+
+// awsEscape encodes everything but the unreserved characters, slashes are
+// kept in paths
+func awsEscape(s string, query bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsQuery is the canonical query string, sorted by the names
+func awsQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, awsEscape(name, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *s3Store) list(prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := storeRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing of %s: %w", s.url(prefix), err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *s3Store) get(key string) ([]byte, error) {
+	req, err := s.request(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return storeRequest(req)
+}
+
+func (s *s3Store) put(key string, data []byte) error {
+	req, err := s.request(http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	_, err = storeRequest(req)
+	return err
+}
+
+// gcsStore uses the JSON API of Google Cloud Storage with the OAuth token in
+// GOOGLE_OAUTH_ACCESS_TOKEN (gcloud auth print-access-token).
+// STORAGE_EMULATOR_HOST selects an emulator like for the Google SDKs.
+type gcsStore struct {
+	bucket string
+	base   string
+	token  string
+}
+
+func newGCSStore(bucket string) (*gcsStore, error) {
+	s := &gcsStore{bucket: bucket, base: "https://storage.googleapis.com", token: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		s.base = strings.TrimRight(host, "/")
+		if !isURL(s.base) {
+			s.base = "http://" + s.base
+		}
+	} else if s.token == "" {
+		return nil, fmt.Errorf("the access token must be set in GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	return s, nil
+}
+
+func (s *gcsStore) url(key string) string {
+	return "gs://" + s.bucket + "/" + key
+}
+
+func (s *gcsStore) do(method string, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return storeRequest(req)
+}
+
+func (s *gcsStore) list(prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+	for {
+		body, err := s.do(http.MethodGet, s.base+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing of %s: %w", s.url(prefix), err)
+		}
+		for _, item := range page.Items {
+			keys = append(keys, item.Name)
+		}
+		if page.NextPageToken == "" {
+			return keys, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (s *gcsStore) get(key string) ([]byte, error) {
+	return s.do(http.MethodGet, s.base+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o/"+url.PathEscape(key)+"?alt=media", nil)
+}
+
+func (s *gcsStore) put(key string, data []byte) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	_, err := s.do(http.MethodPost, s.base+"/upload/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+query.Encode(), data)
+	return err
+}
+
+// azureBlobStore uses the Blob service REST API with the SAS token in
+// AZURE_STORAGE_SAS_TOKEN, which needs the list, read and write permissions
+type azureBlobStore struct {
+	account   string
+	container string
+	base      string // the container
+	sas       url.Values
+}
+
+const azureBlobVersion = "2021-08-06"
+
+func newAzureBlobStore(account string, container string) (*azureBlobStore, error) {
+	sas, err := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+	if err != nil || len(sas) == 0 {
+		return nil, fmt.Errorf("a SAS token must be set in AZURE_STORAGE_SAS_TOKEN")
+	}
+	return &azureBlobStore{
+		account:   account,
+		container: container,
+		base:      "https://" + account + ".blob.core.windows.net/" + url.PathEscape(container),
+		sas:       sas,
+	}, nil
+}
+
+func (s *azureBlobStore) url(key string) string {
+	return "az://" + s.account + "/" + s.container + "/" + key
+}
+
+func (s *azureBlobStore) do(method string, key string, query url.Values, body []byte) ([]byte, error) {
+	u := s.base
+	if key != "" {
+		u += "/" + (&url.URL{Path: key}).EscapedPath()
+	}
+	for name, values := range s.sas {
+		query[name] = values
+	}
+	req, err := http.NewRequest(method, u+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureBlobVersion)
+	if method == http.MethodPut {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return storeRequest(req)
+}
+
+func (s *azureBlobStore) list(prefix string) ([]string, error) {
+	var keys []string
+	var marker string
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		body, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name string
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+		err = xml.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing of %s: %w", s.url(prefix), err)
+		}
+		for _, blob := range page.Blobs {
+			keys = append(keys, blob.Name)
+		}
+		if page.NextMarker == "" {
+			return keys, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+func (s *azureBlobStore) get(key string) ([]byte, error) {
+	return s.do(http.MethodGet, key, url.Values{}, nil)
+}
+
+func (s *azureBlobStore) put(key string, data []byte) error {
+	_, err := s.do(http.MethodPut, key, url.Values{}, data)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+const ocrPrompt = "Transcribe all text in this image exactly as it is written, in reading order. Keep the line breaks, the spelling, the punctuation and the language of the original " +
+	"and don't translate, correct or summarize anything. Don't describe the image and don't add any comments. If there is no text, answer only with [no text]."
+
+// the tokens of an OCR answer, documents have more text than a caption
+const ocrMaxTokens = 4096
+
+// preambles and fences models like to put around a transcription
+var (
+	ocrPreambleRE = regexp.MustCompile(`(?i)^(?:here is|here's|the text|the transcription|transcription)\b[^\n]*:\s*\n`)
+	ocrFenceRE    = regexp.MustCompile("^```[a-z]*\\n([\\s\\S]*?)\\n?```$")
+)
+
+// finishOCR cleans up a transcription without touching the text itself, the
+// line breaks stay but trailing spaces and surrounding empty lines go
+func finishOCR(text string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	text = ocrPreambleRE.ReplaceAllString(text, "")
+	if m := ocrFenceRE.FindStringSubmatch(strings.TrimSpace(text)); m != nil {
+		text = m[1]
+	}
+	if strings.EqualFold(strings.TrimSpace(text), "[no text]") {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
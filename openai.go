@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+const azureKeyEnv = "AZURE_OPENAI_API_KEY"
+
+// openAIClient sends the requests to an OpenAI compatible chat completions
+// endpoint. It implements the same interface as the Ollama client, so the
+// rest of capollama doesn't need to know which backend is used.
+type openAIClient struct {
+	endpoint string
+	header   http.Header
+	http     *http.Client
+}
+
+// newAzureClient creates the client for an Azure OpenAI deployment. Azure
+// addresses the model by the deployment in the URL, needs the api-version
+// query parameter and authenticates with the api-key header.
+func newAzureClient(endpoint string, deployment string, apiVersion string) (*openAIClient, error) {
+	key := os.Getenv(azureKeyEnv)
+	if key == "" {
+		return nil, fmt.Errorf("the API key must be set in %s", azureKeyEnv)
+	}
+	base, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid Azure endpoint %q", endpoint)
+	}
+	u := base.JoinPath("openai", "deployments", deployment, "chat", "completions")
+	u.RawQuery = url.Values{"api-version": {apiVersion}}.Encode()
+
+	header := http.Header{}
+	header.Set("api-key", key)
+	return &openAIClient{endpoint: u.String(), header: header, http: backendClient}, nil
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type openAIPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIRequest struct {
+	Model          string          `json:"model,omitempty"`
+	Messages       []openAIMessage `json:"messages"`
+	MaxTokens      *int            `json:"max_tokens,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	TopP           *float64        `json:"top_p,omitempty"`
+	Seed           *int            `json:"seed,omitempty"`
+	Stop           []string        `json:"stop,omitempty"`
+	ResponseFormat *openAIFormat   `json:"response_format,omitempty"`
+}
+
+// MarshalJSON adds the --extra-body fields, also to the lines of --batch
+func (r openAIRequest) MarshalJSON() ([]byte, error) {
+	type plain openAIRequest
+	return withExtraBody(plain(r))
+}
+
+type openAIFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (c *openAIClient) Generate(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+	messages := promptMessages(req.System, req.Prompt, req.Images)
+	answer, metrics, err := c.complete(ctx, openAIBody(req.Model, messages, req.Options, req.Format))
+	if err != nil {
+		return err
+	}
+	return fn(api.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Response: answer, Done: true, Metrics: metrics})
+}
+
+func (c *openAIClient) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	var messages []openAIMessage
+	for _, msg := range req.Messages {
+		if len(msg.Images) > 0 {
+			m := userMessage(msg.Content, msg.Images)
+			m.Role = msg.Role
+			messages = append(messages, m)
+			continue
+		}
+		messages = append(messages, openAIMessage{Role: msg.Role, Content: msg.Content})
+	}
+	answer, metrics, err := c.complete(ctx, openAIBody(req.Model, messages, req.Options, req.Format))
+	if err != nil {
+		return err
+	}
+	return fn(api.ChatResponse{Model: req.Model, CreatedAt: time.Now(), Message: api.Message{Role: "assistant", Content: answer}, Done: true, Metrics: metrics})
+}
+
+// promptMessages are the messages for a prompt with an optional system prompt
+func promptMessages(system string, prompt string, images []api.ImageData) []openAIMessage {
+	var messages []openAIMessage
+	if system != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: system})
+	}
+	return append(messages, userMessage(prompt, images))
+}
+
+// userMessage puts the images as data URLs next to the prompt
+func userMessage(prompt string, images []api.ImageData) openAIMessage {
+	parts := []openAIPart{{Type: "text", Text: prompt}}
+	for _, img := range images {
+		mime := http.DetectContentType(img)
+		parts = append(parts, openAIPart{
+			Type:     "image_url",
+			ImageURL: &openAIImageURL{URL: "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(img)},
+		})
+	}
+	return openAIMessage{Role: "user", Content: parts}
+}
+
+// openAIBody maps the Ollama options to their OpenAI counterparts
+func openAIBody(model string, messages []openAIMessage, options map[string]any, format string) openAIRequest {
+	body := openAIRequest{Model: model, Messages: messages}
+	if v, ok := options["num_predict"].(int); ok {
+		body.MaxTokens = &v
+	}
+	if v, ok := floatOption(options, "temperature"); ok {
+		body.Temperature = &v
+	}
+	if v, ok := floatOption(options, "top_p"); ok {
+		body.TopP = &v
+	}
+	if v, ok := options["seed"].(int); ok {
+		body.Seed = &v
+	}
+	if v, ok := options["stop"].([]string); ok {
+		body.Stop = v
+	}
+	if format == "json" {
+		body.ResponseFormat = &openAIFormat{Type: "json_object"}
+	}
+	return body
+}
+
+func (c *openAIClient) complete(ctx context.Context, body openAIRequest) (string, api.Metrics, error) {
+	var metrics api.Metrics
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", metrics, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", metrics, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", metrics, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", metrics, err
+	}
+
+	var answer openAIResponse
+	err = json.Unmarshal(raw, &answer)
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := strings.TrimSpace(string(raw))
+		if err == nil && answer.Error != nil {
+			msg = answer.Error.Message
+		}
+		return "", metrics, api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, ErrorMessage: msg}
+	}
+	if err != nil {
+		return "", metrics, fmt.Errorf("unmarshal: %w", err)
+	}
+	if len(answer.Choices) == 0 {
+		return "", metrics, fmt.Errorf("no choices in the answer")
+	}
+	metrics.PromptEvalCount = answer.Usage.PromptTokens
+	metrics.EvalCount = answer.Usage.CompletionTokens
+	return answer.Choices[0].Message.Content, metrics, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var outputFormats = []string{"text", "tsv", "json"}
+
+func isValidFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// result is what gets printed to stdout for every captioned image.
+// Everything else (progress, warnings and errors) goes to stderr.
+type result struct {
+	Page    string        `json:"page,omitempty"`
+	Path    string        `json:"path"`
+	Suffix  string        `json:"suffix,omitempty"`
+	Caption string        `json:"caption"`
+	Counts  *objectCounts `json:"counts,omitempty"`
+	Rating  string        `json:"rating,omitempty"`
+}
+
+// printResult prints a result in the selected format. The text format shows
+// paths relative to the root (and is hidden by --quiet), tsv and json use the
+// paths as they were processed.
+func printResult(args args, res result, root string) {
+	if args.Quiet && args.Format == "text" {
+		return
+	}
+	if fileLog != nil && !logJSON {
+		fileLog.Printf("Captioned %s: %s", res.Path, res.Caption)
+	}
+	if logJSON && args.Format == "text" {
+		// the results are log lines too
+		writeLogEntry(logEntry{Time: time.Now(), Level: levelNames[levelInfo], Path: res.Path, Message: "Captioned", result: &res})
+		return
+	}
+	var record string
+	switch args.Format {
+	case "tsv":
+		fields := []string{res.Path, tsvEscape(res.Caption)}
+		if res.Page != "" {
+			fields = append([]string{res.Page}, fields...)
+		}
+		if res.Suffix != "" {
+			fields = []string{res.Path, res.Suffix, tsvEscape(res.Caption)}
+		}
+		if res.Counts != nil {
+			fields = append(fields, fmt.Sprint(res.Counts.People), fmt.Sprint(res.Counts.Animals), fmt.Sprint(res.Counts.Vehicles))
+		}
+		if res.Rating != "" {
+			fields = append(fields, res.Rating)
+		}
+		record = strings.Join(fields, "\t")
+	case "json":
+		data, err := json.Marshal(res)
+		if err != nil {
+			// can't happen for our plain struct
+			panic(err)
+		}
+		record = string(data)
+	default:
+		record = strings.TrimPrefix(res.Path, root) + ": " + res.Caption
+		if res.Suffix != "" {
+			record = strings.TrimPrefix(res.Path, root) + " (" + res.Suffix + "): " + res.Caption
+		}
+		if res.Page != "" {
+			record = res.Page + ": " + record
+		}
+		if res.Counts != nil {
+			record += " (" + res.Counts.String() + ")"
+		}
+		if res.Rating != "" {
+			record += " [" + res.Rating + "]"
+		}
+	}
+
+	terminator := "\n"
+	if args.Null {
+		terminator = "\x00"
+	}
+	withProgressCleared(func() {
+		printBetweenStreams(record + terminator)
+	})
+}
+
+// tsvEscape keeps a caption on a single tab separated line
+func tsvEscape(text string) string {
+	return strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r").Replace(text)
+}
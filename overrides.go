@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// overridesFile can be dropped into any directory to change the settings for its subtree
+const overridesFile = ".capollama.toml"
+
+// dirSettings are the settings of a .capollama.toml, unset fields are inherited
+type dirSettings struct {
+	Prompt  *string `toml:"prompt"`
+	System  *string `toml:"system"`
+	Start   *string `toml:"start"`
+	End     *string `toml:"end"`
+	Model   *string `toml:"model"`
+	Trigger *string `toml:"trigger"`
+}
+
+// dirOverrides caches the .capollama.toml files of the directories
+type dirOverrides struct {
+	mu   sync.Mutex
+	dirs map[string]*dirSettings // nil if a directory has none
+}
+
+var overrides = &dirOverrides{dirs: map[string]*dirSettings{}}
+
+// kohyaFolderRE matches the folders of kohya training sets (10_ohwx woman)
+var kohyaFolderRE = regexp.MustCompile(`^\d+_(.+)$`)
+
+// apply changes the args with the trigger word of the folder name and the settings
+// of all .capollama.toml files from the root down to the directory of the image,
+// the deepest one wins
+func (o *dirOverrides) apply(args args, path string, root string) (args, error) {
+	args.image = path
+	if args.TriggerFromFolder {
+		m := kohyaFolderRE.FindStringSubmatch(filepath.Base(filepath.Dir(path)))
+		if m != nil {
+			args.Trigger = strings.TrimSpace(m[1])
+		}
+	}
+	args.people = names.match(path, root)
+	dirs, err := settingsDirs(path, root)
+	if err != nil {
+		return args, err
+	}
+	for _, dir := range dirs {
+		settings, err := o.load(dir)
+		if err != nil {
+			return args, err
+		}
+		if settings == nil {
+			continue
+		}
+		set := func(field *string, value *string) {
+			if value != nil {
+				*field = *value
+			}
+		}
+		set(&args.Prompt, settings.Prompt)
+		set(&args.System, settings.System)
+		set(&args.StartCaption, settings.Start)
+		set(&args.EndCaption, settings.End)
+		if !args.keepModel {
+			set(&args.Model, settings.Model)
+		}
+		set(&args.Trigger, settings.Trigger)
+	}
+	return args, nil
+}
+
+// settingsDirs returns the directories from the root (or the file system root
+// without one) down to the directory of the image
+func settingsDirs(path string, root string) ([]string, error) {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	top := ""
+	if root != "" {
+		top, err = filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var dirs []string
+	for {
+		dirs = append([]string{dir}, dirs...)
+		parent := filepath.Dir(dir)
+		if dir == top || parent == dir {
+			return dirs, nil
+		}
+		dir = parent
+	}
+}
+
+func (o *dirOverrides) load(dir string) (*dirSettings, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	settings, ok := o.dirs[dir]
+	if ok {
+		return settings, nil
+	}
+	file := filepath.Join(dir, overridesFile)
+	_, err := os.Stat(file)
+	if err == nil {
+		settings = &dirSettings{}
+		md, err := toml.DecodeFile(file, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", file, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown setting %q in %s", undecoded[0].String(), file)
+		}
+		logVerbose("Using the settings of %s", file)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	o.dirs[dir] = settings
+	return settings, nil
+}
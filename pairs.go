@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pairsPrompt replaces the default prompt with --pairs
+const pairsPrompt = "These images belong together, like before and after or the views of a stereo pair. Describe what they show and the differences between them. Answer only with one or two sentences."
+
+// parsePairs checks the patterns of --pairs, like "*_a.jpg,*_b.jpg"
+func parsePairs(s string) ([]string, error) {
+	patterns := strings.Split(s, ",")
+	for i, pattern := range patterns {
+		patterns[i] = strings.TrimSpace(pattern)
+		if strings.Count(patterns[i], "*") != 1 || strings.ContainsAny(patterns[i], `/\`) {
+			return nil, fmt.Errorf("invalid --pairs pattern %q, it needs one * for the common part of the names", pattern)
+		}
+	}
+	if len(patterns) < 2 {
+		return nil, fmt.Errorf("--pairs needs two or more patterns, like \"*_a.jpg,*_b.jpg\"")
+	}
+	return patterns, nil
+}
+
+// matchStem returns the part of the file name that the * of the pattern matches
+func matchStem(pattern string, name string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	if len(name) < len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
+
+// pairPartners are the other images of the group of an image that matches the
+// first pattern, in the order of the patterns
+func pairPartners(patterns []string, path string) ([]string, bool) {
+	stem, ok := matchStem(patterns[0], filepath.Base(path))
+	if !ok {
+		return nil, false
+	}
+	var partners []string
+	for _, pattern := range patterns[1:] {
+		partners = append(partners, filepath.Join(filepath.Dir(path), strings.Replace(pattern, "*", stem, 1)))
+	}
+	return partners, true
+}
+
+// groupPairs keeps the first image of every complete group, the caption of
+// the group is written for it. The other images, and those that belong to no
+// group, are not captioned on their own.
+func groupPairs(images []imageFile, patterns []string) []imageFile {
+	var firsts []imageFile
+	for _, image := range images {
+		partners, ok := pairPartners(patterns, image.Path)
+		if !ok {
+			continue
+		}
+		complete := true
+		for _, partner := range partners {
+			if !fileExists(partner) {
+				logVerbose("Skipping %s, %s of its group is missing", logPath(image.Path), partner)
+				complete = false
+				break
+			}
+		}
+		if complete {
+			firsts = append(firsts, image)
+		}
+	}
+	return firsts
+}
+
+// loadGroup loads the image and the other images of its group with the
+// preprocessing of the first image. The request of the group is fitted into
+// --max-payload as a whole, so the images share the limit.
+func loadGroup(args args, path string) (string, [][]byte, error) {
+	partners, _ := pairPartners(args.pairs, path)
+	var prompt string
+	var images [][]byte
+	for i, file := range append([]string{path}, partners...) {
+		imgData, err := readImage(file)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read image %s: %w", file, err)
+		}
+		filePrompt, more, err := preprocessImage(args, imgData)
+		if err != nil {
+			if i > 0 {
+				err = fmt.Errorf("%s of the group: %w", file, err)
+			}
+			return "", nil, err
+		}
+		if i == 0 {
+			prompt = filePrompt
+		}
+		images = append(images, more...)
+	}
+	images, err := fitPayload(args, path, images)
+	if err != nil {
+		return "", nil, err
+	}
+	return prompt, images, nil
+}
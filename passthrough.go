@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// extraBody are the --extra-body fields, they are added to the JSON of every
+// request to Azure, the OpenAI batch API and llama.cpp
+var extraBody map[string]any
+
+// parseKeyValues parses the KEY=VALUE of --option and --extra-body. The values
+// of stop are collected into a list, so it can be repeated like in a Modelfile.
+func parseKeyValues(flag string, list []string) (map[string]any, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	values := map[string]any{}
+	for _, item := range list {
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid %s %q, use KEY=VALUE", flag, item)
+		}
+		if key == "stop" {
+			stops, err := stopValues(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", flag, item, err)
+			}
+			previous, _ := values[key].([]string)
+			values[key] = append(previous, stops...)
+			continue
+		}
+		values[key] = inferValue(value)
+	}
+	return values, nil
+}
+
+// inferValue makes an int, a float, a bool or the decoded JSON (an array, an
+// object or a "quoted" string) of the value, everything else is text
+func inferValue(value string) any {
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	// inf and nan are text, JSON has no such numbers
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") || strings.HasPrefix(value, `"`) {
+		var decoded any
+		if json.Unmarshal([]byte(value), &decoded) == nil {
+			return decoded
+		}
+	}
+	return value
+}
+
+// stopValues is a single stop sequence or a JSON list of them
+func stopValues(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		if s, ok := inferValue(value).(string); ok {
+			return []string{s}, nil
+		}
+		return []string{value}, nil
+	}
+	var stops []string
+	err := json.Unmarshal([]byte(value), &stops)
+	if err != nil {
+		return nil, fmt.Errorf("stop must be text or a JSON list of texts")
+	}
+	return stops, nil
+}
+
+// floatOption reads a number option, --option gives 1 as an int
+func floatOption(options map[string]any, name string) (float64, bool) {
+	switch v := options[name].(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// withExtraBody encodes the request with the --extra-body fields, which
+// replace the fields of the same name
+func withExtraBody(body any) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil || len(extraBody) == 0 {
+		return data, err
+	}
+	var merged map[string]any
+	err = json.Unmarshal(data, &merged)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(merged, extraBody)
+	return json.Marshal(merged)
+}
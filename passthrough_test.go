@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInferValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  any
+	}{
+		{"4096", 4096},
+		{"-1", -1},
+		{"+2", 2},
+		{"0.7", 0.7},
+		{"1e3", 1000.0},
+		{".5", 0.5},
+		{"true", true},
+		{"false", false},
+		{"True", "True"},
+		{"inf", "inf"},
+		{"NaN", "NaN"},
+		{"", ""},
+		{"some text", "some text"},
+		{" 1", " 1"},
+		{`"42"`, "42"},
+		{`"quoted text"`, "quoted text"},
+		{`[1,"a"]`, []any{1.0, "a"}},
+		{`{"type":"json_object"}`, map[string]any{"type": "json_object"}},
+		{`[broken`, `[broken`},
+		{`{not json}`, `{not json}`},
+		{`"unterminated`, `"unterminated`},
+		{"null", "null"},
+	}
+	for _, tt := range tests {
+		got := inferValue(tt.value)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("inferValue(%q) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	got, err := parseKeyValues("--option", []string{"num_ctx=8192", "temperature=0.2", "stop=###", `stop=["<|im_end|>","</s>"]`, `stop="a b"`, " seed =7", "x="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"num_ctx":     8192,
+		"temperature": 0.2,
+		"stop":        []string{"###", "<|im_end|>", "</s>", "a b"},
+		"seed":        7,
+		"x":           "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeyValues = %#v, want %#v", got, want)
+	}
+
+	for _, item := range []string{"num_ctx", "=1", `stop=[1,2]`, "stop=[broken"} {
+		_, err := parseKeyValues("--option", []string{item})
+		if err == nil {
+			t.Errorf("no error for %q", item)
+		}
+	}
+	values, err := parseKeyValues("--option", nil)
+	if values != nil || err != nil {
+		t.Errorf("parseKeyValues(nil) = %v, %v", values, err)
+	}
+}
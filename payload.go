@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// the smallest side length the payload guard scales an image down to
+const minPayloadSize = 64
+
+// fitPayload re-encodes the images that make the request larger than
+// --max-payload, each image gets a share of the limit by its size
+func fitPayload(args args, path string, images [][]byte) ([][]byte, error) {
+	limit := int64(args.MaxPayload * 1024 * 1024)
+	size := encodedSize(images)
+	if limit <= 0 || size <= limit {
+		return images, nil
+	}
+	fitted := make([][]byte, len(images))
+	for i, img := range images {
+		budget := limit * encodedSize([][]byte{img}) / size
+		if encodedSize([][]byte{img}) <= budget {
+			fitted[i] = img
+			continue
+		}
+		shrunk, err := transcoding.run(img, func(buf *bytes.Buffer) error {
+			return shrinkImage(buf, img, budget)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not fit %s into --max-payload: %w", path, err)
+		}
+		fitted[i] = shrunk
+	}
+	logVerbose("Re-encoded %s to fit --max-payload (%.1f MB instead of %.1f MB)", path,
+		float64(encodedSize(fitted))/(1024*1024), float64(size)/(1024*1024))
+	return fitted, nil
+}
+
+// shrinkImage encodes the image as JPEG and scales it down until its
+// base64 encoding fits into the budget
+func shrinkImage(buf *bytes.Buffer, imgData []byte, budget int64) error {
+	src, _, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	// the JPEG has no EXIF, so the orientation is applied to the pixels
+	img := flatten(orient(toNRGBA(src), exifOrientation(imgData)))
+	for {
+		buf.Reset()
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return err
+		}
+		encoded := int64(base64.StdEncoding.EncodedLen(buf.Len()))
+		if encoded <= budget {
+			return nil
+		}
+		longest := max(img.Rect.Dx(), img.Rect.Dy())
+		if longest <= minPayloadSize {
+			return fmt.Errorf("still %d bytes at %d pixels", encoded, longest)
+		}
+		scale := math.Sqrt(float64(budget)/float64(encoded)) * 0.95
+		img = resizeToFit(img, max(int(float64(longest)*scale), minPayloadSize))
+	}
+}
+
+// flatten puts transparent pixels on white, JPEG has no alpha channel
+func flatten(img *image.NRGBA) *image.NRGBA {
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := uint32(img.Pix[i+3])
+		if a == 255 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			img.Pix[i+c] = uint8((uint32(img.Pix[i+c])*a + 255*(255-a)) / 255)
+		}
+		img.Pix[i+3] = 255
+	}
+	return img
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pdfRenderer rasterizes the pages of a PDF, it is part of poppler-utils
+const pdfRenderer = "pdftoppm"
+
+const pdfSummaryPrompt = "These are the descriptions of the %d pages of a document:\n\n%s\nDescribe the document as a whole in the same style. Answer only with the description."
+
+// isPDFFile checks if the file has the extension of a PDF
+func isPDFFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
+
+// pageSuffix is the suffix of the caption file of a page (doc.p3.txt)
+func pageSuffix(page int) string {
+	return fmt.Sprintf(".p%d.txt", page)
+}
+
+// pdfDoneFile is the file that exists once the PDF was captioned: the caption
+// of the whole document with --pdf-summary and the caption of the first page otherwise
+func pdfDoneFile(args args, path string) string {
+	if args.PDFSummary {
+		return captionFile(path)
+	}
+	return outputFile(path, pageSuffix(1))
+}
+
+// renderPDF renders the pages (or the first --pdf-pages) of the PDF as PNG files
+// into a temporary folder that the caller removes
+func renderPDF(args args, path string) (string, []string, error) {
+	dir, err := os.MkdirTemp("", appName)
+	if err != nil {
+		return "", nil, err
+	}
+	cmdline := []string{"-png", "-r", strconv.Itoa(args.PDFDPI)}
+	if args.PDFPages > 0 {
+		cmdline = append(cmdline, "-l", strconv.Itoa(args.PDFPages))
+	}
+	cmdline = append(cmdline, path, filepath.Join(dir, "page"))
+	out, err := exec.Command(pdfRenderer, cmdline...).CombinedOutput()
+	if err != nil {
+		return dir, nil, fmt.Errorf("%w: %s failed: %v %s", errCorruptImage, pdfRenderer, err, strings.TrimSpace(string(out)))
+	}
+	// the page numbers are padded to the same width, so the names sort by page
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return dir, nil, err
+	}
+	var pages []string
+	for _, entry := range entries {
+		pages = append(pages, filepath.Join(dir, entry.Name()))
+	}
+	if len(pages) == 0 {
+		return dir, nil, fmt.Errorf("%w: no pages", errCorruptImage)
+	}
+	return dir, pages, nil
+}
+
+// processPDF captions the pages of the PDF and writes a caption file per page,
+// or one caption of the whole document with --pdf-summary
+func processPDF(ol *hostPool, args args, path string, root string) error {
+	args, err := overrides.apply(args, path, root)
+	if err != nil {
+		return err
+	}
+	dir, pages, err := renderPDF(args, path)
+	defer os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var usage tokenUsage
+	pageArgs := args
+	if args.PDFSummary {
+		// the trigger and the --start and --end are only added to the summary
+		pageArgs.Trigger, pageArgs.StartCaption, pageArgs.EndCaption = "", "", ""
+	}
+	var answers []result
+	captions := make([]string, len(pages))
+	for i, page := range pages {
+		suffix := pageSuffix(i + 1)
+		if !args.PDFSummary && !args.Force && fileExists(outputFile(path, suffix)) {
+			captions[i], _ = readCaption(outputFile(path, suffix))
+			continue
+		}
+		data, err := os.ReadFile(page)
+		if err != nil {
+			return err
+		}
+		prompt, images, err := prepareImage(pageArgs, path, data)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+		logVerbose("Captioning page %d of %s (%d bytes) with %s", i+1, logPath(path), len(images[0]), args.Model)
+		captions[i], err = generateCaption(ol, pageArgs, &usage, path, prompt, images...)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+		if args.PDFSummary {
+			continue
+		}
+		captions[i] = mergeExisting(args, outputFile(path, suffix), captions[i])
+		answer := result{Path: path, Suffix: suffix, Caption: captions[i]}
+		printResult(args, answer, root)
+		answers = append(answers, answer)
+		if !args.DryRun {
+			err = writeOutput(outputFile(path, suffix), captions[i])
+			if err != nil {
+				return fmt.Errorf("could not write file: %w", err)
+			}
+		}
+	}
+
+	var summary string
+	if args.PDFSummary {
+		summary, err = summarizePages(ol, args, &usage, path, captions)
+		if err != nil {
+			return err
+		}
+		err = saveResult(args, path, root, captionFile(path), imageMetadata{Caption: summary})
+		if err != nil {
+			return err
+		}
+	}
+	logVerbose("Captioned %d pages of %s in %s (%d prompt + %d completion tokens)", len(pages), logPath(path), time.Since(start).Round(time.Millisecond), usage.Prompt, usage.Completion)
+	report.add(args, path, root, summary, answers)
+	return nil
+}
+
+// summarizePages combines the captions of the pages: the transcriptions of
+// --mode ocr are joined with form feeds (like pdftotext does), the tags are
+// merged and the captions are summarized by the model
+func summarizePages(ol *hostPool, args args, usage *tokenUsage, path string, captions []string) (string, error) {
+	switch {
+	case args.Mode == "ocr":
+		return strings.Join(captions, "\n\f"), nil
+	case args.Mode == "tags":
+		return finishTags(args, strings.Join(captions, ", ")), nil
+	case len(captions) == 1:
+		return finishCaption(args, path, captions[0]), nil
+	}
+	var pages strings.Builder
+	for i, caption := range captions {
+		fmt.Fprintf(&pages, "Page %d: %s\n", i+1, caption)
+	}
+	logVerbose("Summarizing the %d pages of %s", len(captions), path)
+	answer, err := askInLanguage(ol, args, usage, fmt.Sprintf(pdfSummaryPrompt, len(captions), pages.String())+localeHint(args))
+	if err != nil {
+		return "", err
+	}
+	answer, err = shortenAnswer(ol, args, usage, strings.TrimSpace(answer))
+	if err != nil {
+		return "", err
+	}
+	return finishCaption(args, path, answer), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// normalizeModel brings a model name into the form the list API uses, so
+// "llava", "library/llava" and "registry.ollama.ai/library/llava:latest" are equal
+func normalizeModel(name string) string {
+	name = strings.TrimPrefix(name, "registry.ollama.ai/")
+	name = strings.TrimPrefix(name, "library/")
+	if !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		name += ":latest"
+	}
+	return name
+}
+
+// hasVision checks for the families of the vision encoders
+func hasVision(details api.ModelDetails) bool {
+	for _, family := range details.Families {
+		if family == "clip" || family == "mllama" {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight checks on every Ollama host that the model exists and supports
+// images before the first image is sent. Unreachable hosts are skipped.
+func preflight(ol *hostPool, model string) error {
+	ctx := context.Background()
+	want := normalizeModel(model)
+	checked := 0
+	for _, h := range ol.hosts {
+		if h.ollama == nil {
+			continue
+		}
+		list, err := h.ollama.List(ctx)
+		if err != nil {
+			if isUnreachable(err) && len(ol.hosts) > 1 {
+				ol.mu.Lock()
+				ol.markDown(h, err)
+				ol.mu.Unlock()
+				continue
+			}
+			return fmt.Errorf("could not list the models of %s: %w", h.url, err)
+		}
+		checked++
+
+		var found *api.ListModelResponse
+		var vision []string
+		for i, m := range list.Models {
+			if normalizeModel(m.Name) == want {
+				found = &list.Models[i]
+			}
+			if hasVision(m.Details) {
+				vision = append(vision, m.Name)
+			}
+		}
+		installed := "none"
+		if len(vision) > 0 {
+			installed = strings.Join(vision, ", ")
+		}
+		if found == nil {
+			return fmt.Errorf("model %q is not installed on %s (pull it with \"ollama pull %s\"), installed vision models: %s", model, h.url, model, installed)
+		}
+
+		show, err := h.ollama.Show(ctx, &api.ShowRequest{Model: found.Name})
+		if err != nil {
+			return fmt.Errorf("could not show model %q on %s: %w", found.Name, h.url, err)
+		}
+		if len(show.ProjectorInfo) == 0 && !hasVision(show.Details) && !hasVision(found.Details) {
+			return fmt.Errorf("model %q on %s does not support images, installed vision models: %s", model, h.url, installed)
+		}
+		logVerbose("Model %s is available on %s", found.Name, h.url)
+	}
+	if checked == 0 && len(ol.hosts) > 0 && ol.hosts[0].ollama != nil {
+		return errNoHosts
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// preprocessStep is one step of the --preprocess chain
+type preprocessStep struct {
+	Name  string
+	Value string
+}
+
+var preprocessSteps = []string{"autorotate", "resize", "crop", "sharpen", "grayscale"}
+
+// parsePreprocess parses a chain like "autorotate,resize=1024,crop=1:1,sharpen=0.5,grayscale"
+func parsePreprocess(chain string) ([]preprocessStep, error) {
+	var steps []preprocessStep
+	for _, part := range strings.Split(chain, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		step := preprocessStep{Name: name, Value: value}
+		var err error
+		switch name {
+		case "autorotate", "grayscale":
+			if value != "" {
+				err = fmt.Errorf("%s takes no value", name)
+			}
+		case "resize":
+			_, err = step.resizeSize()
+		case "crop":
+			_, _, err = step.cropAspect()
+		case "sharpen":
+			_, err = step.sharpenAmount()
+		default:
+			err = fmt.Errorf("unknown step %q (use %s)", name, strings.Join(preprocessSteps, ", "))
+		}
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (s preprocessStep) resizeSize() (int, error) {
+	size, err := strconv.Atoi(s.Value)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("resize needs the maximum size in pixels (resize=1024)")
+	}
+	return size, nil
+}
+
+func (s preprocessStep) cropAspect() (float64, float64, error) {
+	w, h, ok := strings.Cut(s.Value, ":")
+	aw, errW := strconv.ParseFloat(w, 64)
+	ah, errH := strconv.ParseFloat(h, 64)
+	if !ok || errW != nil || errH != nil || aw <= 0 || ah <= 0 {
+		return 0, 0, fmt.Errorf("crop needs an aspect ratio (crop=16:9)")
+	}
+	return aw, ah, nil
+}
+
+func (s preprocessStep) sharpenAmount() (float64, error) {
+	if s.Value == "" {
+		return 1, nil
+	}
+	amount, err := strconv.ParseFloat(s.Value, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("sharpen needs a positive amount (sharpen=0.5)")
+	}
+	return amount, nil
+}
+
+// Preprocess applies the steps to the image data. The original data is
+// returned unchanged without steps, otherwise the result is encoded as PNG
+// for PNG input and as JPEG for everything else.
+func Preprocess(imgData []byte, steps []preprocessStep) ([]byte, error) {
+	if len(steps) == 0 {
+		return imgData, nil
+	}
+	return transcoding.run(imgData, func(buf *bytes.Buffer) error {
+		return preprocess(buf, imgData, steps)
+	})
+}
+
+func preprocess(buf *bytes.Buffer, imgData []byte, steps []preprocessStep) error {
+	src, format, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img := toNRGBA(src)
+	for _, step := range steps {
+		switch step.Name {
+		case "autorotate":
+			img = orient(img, exifOrientation(imgData))
+		case "resize":
+			size, _ := step.resizeSize()
+			img = resizeToFit(img, size)
+		case "crop":
+			aw, ah, _ := step.cropAspect()
+			img = cropToAspect(img, aw/ah)
+		case "sharpen":
+			amount, _ := step.sharpenAmount()
+			img = sharpen(img, amount)
+		case "grayscale":
+			img = grayscale(img)
+		}
+	}
+
+	if format == "png" {
+		return png.Encode(buf, img)
+	}
+	return jpeg.Encode(buf, img, &jpeg.Options{Quality: 90})
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if img, ok := src.(*image.NRGBA); ok && img.Rect.Min == (image.Point{}) {
+		return img
+	}
+	b := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Rect, src, b.Min, draw.Src)
+	return img
+}
+
+// exifOrientation reads the orientation tag of a JPEG and returns 1 (normal)
+// if there is none
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			// start of scan or broken segment, so there is no EXIF
+			return 1
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient transforms the image according to the EXIF orientation so its pixels are upright
+func orient(img *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetNRGBA(x, y, img.NRGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// resizeToFit scales the image down so its longest side is at most size pixels
+func resizeToFit(img *image.NRGBA, size int) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, max(dw, 1), max(dh, 1)))
+	draw.CatmullRom.Scale(dst, dst.Rect, img, img.Rect, draw.Src, nil)
+	return dst
+}
+
+// cropToAspect cuts the image in the center to the aspect ratio (width / height)
+func cropToAspect(img *image.NRGBA, aspect float64) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cw, ch := w, int(float64(w)/aspect+0.5)
+	if ch > h {
+		cw, ch = int(float64(h)*aspect+0.5), h
+	}
+	cw, ch = max(cw, 1), max(ch, 1)
+	x0, y0 := (w-cw)/2, (h-ch)/2
+	return toNRGBA(img.SubImage(image.Rect(x0, y0, x0+cw, y0+ch)))
+}
+
+// sharpen applies a simple 3x3 sharpening kernel with the given strength
+func sharpen(img *image.NRGBA, amount float64) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dst := image.NewNRGBA(img.Rect)
+	offset := func(x, y int) int {
+		return img.PixOffset(min(max(x, 0), w-1), min(max(y, 0), h-1))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := offset(x, y)
+			neighbours := [4]int{offset(x-1, y), offset(x+1, y), offset(x, y-1), offset(x, y+1)}
+			for ch := 0; ch < 3; ch++ {
+				sum := 0.0
+				for _, n := range neighbours {
+					sum += float64(img.Pix[n+ch])
+				}
+				v := float64(img.Pix[i+ch])*(1+4*amount) - sum*amount
+				dst.Pix[i+ch] = uint8(min(max(v+0.5, 0), 255))
+			}
+			dst.Pix[i+3] = img.Pix[i+3]
+		}
+	}
+	return dst
+}
+
+func grayscale(img *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			g := color.GrayModel.Convert(color.NRGBA{R: c.R, G: c.G, B: c.B, A: 255}).(color.Gray)
+			dst.SetNRGBA(x, y, color.NRGBA{R: g.Y, G: g.Y, B: g.Y, A: c.A})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressWidth = 30
+
+// progress shows how far a batch is, with throughput and estimated remaining time.
+// All methods do nothing on a nil progress, so it can be used unconditionally.
+type progress struct {
+	mu    sync.Mutex
+	total int
+	done  int
+	start time.Time
+	tty   bool
+	drawn bool
+}
+
+// activeProgress is cleared and redrawn around every other output on a terminal
+var activeProgress *progress
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func startProgress(total int) *progress {
+	p := &progress{total: total, start: time.Now(), tty: isTerminal(os.Stderr) && !logJSON}
+	if p.tty {
+		activeProgress = p
+		log.SetOutput(progressWriter{logOutput})
+		p.mu.Lock()
+		p.draw()
+		p.mu.Unlock()
+	}
+	return p
+}
+
+// step marks one image as done
+func (p *progress) step() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if p.tty {
+		p.draw()
+	} else if logJSON {
+		logMessage(levelInfo, "Progress: %s", p.status())
+	} else {
+		fmt.Fprintln(os.Stderr, "Progress: "+p.status())
+	}
+}
+
+// finish ends the progress bar line
+func (p *progress) finish() {
+	if p == nil || !p.tty {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drawn {
+		fmt.Fprintln(os.Stderr)
+		p.drawn = false
+	}
+	activeProgress = nil
+	log.SetOutput(logOutput)
+}
+
+func (p *progress) status() string {
+	elapsed := time.Since(p.start)
+	percent := 100
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	status := fmt.Sprintf("%d/%d (%d%%) elapsed %s", p.done, p.total, percent, elapsed.Round(time.Second))
+	if p.done > 0 {
+		perImage := elapsed / time.Duration(p.done)
+		remaining := perImage * time.Duration(p.total-p.done)
+		status += fmt.Sprintf(", %.1f images/min, remaining %s", float64(p.done)/elapsed.Minutes(), remaining.Round(time.Second))
+	}
+	return status
+}
+
+// draw needs the lock held
+func (p *progress) draw() {
+	filled := progressWidth
+	if p.total > 0 {
+		filled = p.done * progressWidth / p.total
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressWidth-filled)
+	fmt.Fprintf(os.Stderr, "\r\033[K[%s] %s", bar, p.status())
+	p.drawn = true
+}
+
+// clear removes the bar before other output is written, it needs the lock held
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// withProgressCleared writes other output without garbling the progress bar
+func withProgressCleared(write func()) {
+	p := activeProgress
+	if p == nil {
+		write()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	write()
+	p.draw()
+}
+
+// progressWriter is used for the logger while the progress bar is shown
+type progressWriter struct {
+	w io.Writer
+}
+
+func (pw progressWriter) Write(b []byte) (n int, err error) {
+	withProgressCleared(func() {
+		n, err = pw.w.Write(b)
+	})
+	return n, err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// extraPrompt is an additional prompt of --extra-prompt or --prompts that
+// writes its answer to its own file next to the image
+type extraPrompt struct {
+	Suffix string
+	Prompt string
+}
+
+// parseExtraPrompts parses the prompts in the form SUFFIX=PROMPT, the file
+// has one of them per line (empty lines and lines starting with # are ignored)
+func parseExtraPrompts(specs []string, file string) ([]extraPrompt, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			specs = append(specs, line)
+		}
+		err = scanner.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var prompts []extraPrompt
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		suffix, prompt, ok := strings.Cut(spec, "=")
+		suffix = strings.TrimSpace(suffix)
+		prompt = strings.TrimSpace(prompt)
+		if !ok || prompt == "" || !strings.HasPrefix(suffix, ".") || strings.ContainsAny(suffix, `/\`) {
+			return nil, fmt.Errorf("invalid prompt %q (use SUFFIX=PROMPT like .tags.txt=List keywords)", spec)
+		}
+		if reservedSuffix(suffix) {
+			return nil, fmt.Errorf("the suffix %s can't be used for a prompt, it is an image or a file that %s writes itself", suffix, appName)
+		}
+		if seen[suffix] {
+			return nil, fmt.Errorf("the suffix %s is used more than once", suffix)
+		}
+		seen[suffix] = true
+		prompts = append(prompts, extraPrompt{Suffix: suffix, Prompt: prompt})
+	}
+	return prompts, nil
+}
+
+// pageSuffixRE matches the caption files of the PDF pages (doc.p3.txt)
+var pageSuffixRE = regexp.MustCompile(`^\.p\d+\.txt$`)
+
+// reservedSuffix checks if the answers of a prompt with this suffix would
+// replace the image (a.jpg for .jpg), become an image of the next scan or
+// clobber the caption, the metadata, a sidecar or a backup. The file systems
+// of Windows and macOS ignore the case.
+func reservedSuffix(suffix string) bool {
+	suffix = strings.ToLower(suffix)
+	switch suffix {
+	case ".txt", ".json", dualSuffix, candidatesSuffix:
+		return true
+	}
+	return strings.HasSuffix(suffix, ".xmp") || strings.HasSuffix(suffix, backupSuffix) || pageSuffixRE.MatchString(suffix) ||
+		isImageFile("image"+suffix) || isPDFFile(suffix)
+}
+
+// outputFile returns the name of the file with this suffix that belongs to the image
+func outputFile(imagePath string, suffix string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + suffix
+}
+
+// hasAllOutputs checks if the caption and the answers of all extra prompts exist
+func hasAllOutputs(args args, imagePath string) bool {
+	if isPDFFile(imagePath) {
+		return fileExists(pdfDoneFile(args, imagePath))
+	}
+	if len(args.Models) > 1 {
+		for _, model := range args.Models {
+			if !fileExists(outputFile(imagePath, modelSuffix(model))) {
+				return false
+			}
+		}
+		return true
+	}
+	if !fileExists(captionFile(imagePath)) {
+		return false
+	}
+	if args.Mode == "dual" && !fileExists(outputFile(imagePath, dualSuffix)) {
+		return false
+	}
+	for _, extra := range args.prompts {
+		if !fileExists(outputFile(imagePath, extra.Suffix)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasExtraPrompt checks if one of the extra prompts writes to the suffix
+func hasExtraPrompt(prompts []extraPrompt, suffix string) bool {
+	for _, extra := range prompts {
+		if extra.Suffix == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// outputNames return the names of the files that are written for an image,
+// one for each kind of output
+func outputNames(args args) []func(string) string {
+	withSuffix := func(suffix string) func(string) string {
+		return func(imagePath string) string { return outputFile(imagePath, suffix) }
+	}
+	names := []func(string) string{captionFile, metadataFile, withSuffix(dualSuffix), withSuffix(candidatesSuffix)}
+	for _, model := range args.Models {
+		names = append(names, withSuffix(modelSuffix(model)))
+	}
+	for _, extra := range args.prompts {
+		names = append(names, withSuffix(extra.Suffix))
+	}
+	if args.XMP != "" {
+		names = append(names, func(imagePath string) string { return xmpSidecar(args, imagePath) })
+	}
+	return names
+}
+
+// outputFiles returns the files that are written for the image (if they exist)
+func outputFiles(args args, imagePath string) []string {
+	var existing []string
+	for _, name := range outputNames(args) {
+		file := name(imagePath)
+		if fileExists(file) && !contains(existing, file) {
+			existing = append(existing, file)
+		}
+	}
+	return existing
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
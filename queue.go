@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+// queueEvent is a line of the queue, an append only journal of JSON lines
+// like --state. Clients and the daemon only append (the daemon compacts it
+// when it starts), replaying the events gives the jobs, so the queue survives
+// restarts and needs no database.
+type queueEvent struct {
+	Op        string    `json:"op"`           // add, start, done, fail or daemon
+	ID        int       `json:"id,omitempty"` // of the job, with daemon the highest job so far
+	Path      string    `json:"path,omitempty"`
+	Priority  int       `json:"priority,omitempty"`
+	Captioned int       `json:"captioned,omitempty"`
+	Failed    int       `json:"failed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// queueJob is a path in the queue, the jobs are numbered in the order of
+// their add events (a compacted journal records the numbers)
+type queueJob struct {
+	ID        int
+	Path      string
+	Priority  int
+	Status    string // pending, running, done or failed
+	Added     time.Time
+	Started   time.Time
+	Finished  time.Time
+	Captioned int
+	Failed    int
+	Error     string
+}
+
+// queueState is the replayed journal
+type queueState struct {
+	jobs   []*queueJob
+	byID   map[int]*queueJob
+	lastID int         // the highest job number, also of the compacted jobs
+	daemon *queueEvent // the last start of the daemon
+}
+
+// readQueue replays the journal, a missing file is an empty queue
+func readQueue(file string) (*queueState, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return replayQueue(file, bytes.NewReader(nil))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return replayQueue(file, f)
+}
+
+// replayQueue replays the events of the journal
+func replayQueue(file string, r io.Reader) (*queueState, error) {
+	q := &queueState{byID: map[int]*queueJob{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var ev queueEvent
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			// a line cut off by a crash while writing
+			logError("Ignoring line %d of %s: %v", line, file, err)
+			continue
+		}
+		if ev.Op == "add" {
+			id := ev.ID
+			if id <= q.lastID {
+				id = q.lastID + 1
+			}
+			q.lastID = id
+			job := &queueJob{ID: id, Path: ev.Path, Priority: ev.Priority, Status: "pending", Added: ev.Time}
+			q.jobs = append(q.jobs, job)
+			q.byID[id] = job
+			continue
+		}
+		if ev.Op == "daemon" {
+			// the jobs that were running when the daemon stopped are done again
+			for _, job := range q.jobs {
+				if job.Status == "running" {
+					job.Status = "pending"
+				}
+			}
+			q.daemon = &ev
+			q.lastID = max(q.lastID, ev.ID)
+			continue
+		}
+		job, ok := q.byID[ev.ID]
+		if !ok {
+			continue
+		}
+		switch ev.Op {
+		case "start":
+			job.Status = "running"
+			job.Started = ev.Time
+		case "done", "fail":
+			job.Status = map[string]string{"done": "done", "fail": "failed"}[ev.Op]
+			job.Finished = ev.Time
+			job.Captioned = ev.Captioned
+			job.Failed = ev.Failed
+			job.Error = ev.Error
+		}
+	}
+	return q, scanner.Err()
+}
+
+// next is the pending job with the highest priority, the oldest first
+func (q *queueState) next() *queueJob {
+	var best *queueJob
+	for _, job := range q.jobs {
+		if job.Status == "pending" && (best == nil || job.Priority > best.Priority) {
+			best = job
+		}
+	}
+	return best
+}
+
+// appendQueue appends the events with one write, so lines of clients and the
+// daemon don't mix
+func appendQueue(file string, events ...queueEvent) error {
+	var data []byte
+	for _, ev := range events {
+		if ev.Time.IsZero() {
+			ev.Time = time.Now()
+		}
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// compactQueue writes the start event of the daemon. The journal is rewritten
+// without the finished jobs first (to a temporary file that replaces it, like
+// --state), the lines that clients append meanwhile are copied over.
+func compactQueue(file string, start queueEvent) error {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return appendQueue(file, start)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	q, err := replayQueue(file, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	start.ID = q.lastID
+	var events []queueEvent
+	for _, job := range q.jobs {
+		if job.Status == "pending" || job.Status == "running" {
+			events = append(events, queueEvent{Op: "add", ID: job.ID, Path: job.Path, Priority: job.Priority, Time: job.Added})
+		}
+	}
+	if len(events) == len(q.jobs) {
+		return appendQueue(file, start)
+	}
+
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	err = appendQueue(tmp, append(events, start)...)
+	if err == nil {
+		err = copyAppended(f, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	logInfo("Compacted %s, removed %d finished jobs", file, len(q.jobs)-len(events))
+	// a client that opened the journal before the rename wrote to the old file
+	return copyAppended(f, file)
+}
+
+// copyAppended appends what was written to the journal after the last read
+func copyAppended(f *os.File, file string) error {
+	rest, err := io.ReadAll(f)
+	if err != nil || len(rest) == 0 {
+		return err
+	}
+	out, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(rest)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// daemonArgs are the flags of "capollama daemon", all flags of caption work
+// too and are used for every job
+type daemonArgs struct {
+	args
+	Queue string        `arg:"--queue" help:"The queue file, jobs are added with \"capollama enqueue\"" default:"capollama-queue.jsonl"`
+	Poll  time.Duration `arg:"--poll" help:"Look for new jobs after this interval when the queue is empty" default:"5s"`
+}
+
+func (daemonArgs) Description() string {
+	return "Keeps running and captions the folders and images of the queue by priority\n"
+}
+
+func (daemonArgs) Epilogue() string {
+	return ""
+}
+
+// parseDaemon handles "capollama daemon [--queue FILE]"
+func parseDaemon(cmdline []string) (*arg.Parser, args) {
+	da := daemonArgs{args: args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}}
+	p := newParser(appName+" daemon", &da)
+	p.MustParse(cmdline)
+	if da.Path != "" {
+		p.Fail("the daemon takes no PATH, add it with \"" + appName + " enqueue\"")
+	}
+	if da.Poll <= 0 {
+		p.Fail("--poll must be positive")
+	}
+	if da.FilesFrom != "" || da.URLs != "" || da.Batch != "" || da.WordPress != "" || da.Audit != "" || da.AltData != "" || da.FillAlt {
+		p.Fail("the daemon can't be used with --files-from, --urls, --batch, --wordpress, --audit, --alt-data or --fill-alt")
+	}
+	// without a state the first failing image would stop the daemon (and the
+	// job would fail again after every restart)
+	if da.State == "" {
+		da.State = da.Queue + ".state"
+	}
+	da.args.queueFile = da.Queue
+	da.args.queuePoll = da.Poll
+	return p, da.args
+}
+
+// runDaemon captions the jobs of the queue one after the other, each with
+// the --workers of the configuration
+func runDaemon(ol *hostPool, args args, state *runState, imported *skipList) {
+	err := compactQueue(args.queueFile, queueEvent{Op: "daemon", PID: os.Getpid()})
+	if err != nil {
+		log.Fatalf("Could not write queue: %v", err)
+	}
+	logInfo("Waiting for jobs in %s", args.queueFile)
+	for {
+		q, err := readQueue(args.queueFile)
+		if err != nil {
+			log.Fatalf("Could not read queue: %v", err)
+		}
+		job := q.next()
+		if job == nil {
+			time.Sleep(args.queuePoll)
+			continue
+		}
+		err = appendQueue(args.queueFile, queueEvent{Op: "start", ID: job.ID})
+		if err != nil {
+			log.Fatalf("Could not write queue: %v", err)
+		}
+		logInfo("Starting job %d (priority %d): %s", job.ID, job.Priority, job.Path)
+		jobArgs := args
+		jobArgs.Path = job.Path
+		failed := stats.failures()
+		b, err := captionImages(ol, jobArgs, state, imported)
+		done := queueEvent{Op: "done", ID: job.ID, Captioned: b.Captioned, Failed: stats.failures() - failed}
+		if err != nil {
+			logError("Job %d failed: %v", job.ID, err)
+			done.Op, done.Error = "fail", err.Error()
+		} else {
+			logInfo("Finished job %d: captioned %d images", job.ID, b.Captioned)
+		}
+		err = appendQueue(args.queueFile, done)
+		if err != nil {
+			log.Fatalf("Could not write queue: %v", err)
+		}
+		webhook.summary(&b)
+		if args.Summary != "" {
+			err = stats.writeJSON(args.Summary, args)
+			if err != nil {
+				logError("Could not write summary: %v", err)
+			}
+		}
+	}
+}
+
+// enqueueArgs are the flags of "capollama enqueue"
+type enqueueArgs struct {
+	Queue    string   `arg:"--queue" help:"The queue file of the daemon" default:"capollama-queue.jsonl"`
+	Priority int      `arg:"--priority" help:"Jobs with a higher priority are captioned first"`
+	Paths    []string `arg:"positional,required" help:"Folders or images to caption"`
+}
+
+func (enqueueArgs) Description() string {
+	return "Adds folders and images to the queue of \"capollama daemon\"\n"
+}
+
+// runEnqueue handles "capollama enqueue [--priority N] PATH..."
+func runEnqueue(cmdline []string) {
+	var a enqueueArgs
+	p := newParser(appName+" enqueue", &a)
+	p.MustParse(cmdline)
+	var events []queueEvent
+	for _, path := range a.Paths {
+		// the daemon may run in another directory
+		abs, err := filepath.Abs(path)
+		if err == nil {
+			_, err = os.Stat(abs)
+		}
+		if err != nil {
+			p.Fail(err.Error())
+		}
+		events = append(events, queueEvent{Op: "add", Path: abs, Priority: a.Priority})
+	}
+	err := appendQueue(a.Queue, events...)
+	if err != nil {
+		log.Fatalf("Could not write queue: %v", err)
+	}
+	logInfo("Queued %d jobs in %s", len(events), a.Queue)
+}
+
+// queueArgs are the flags of "capollama queue"
+type queueArgs struct {
+	Queue string `arg:"--queue" help:"The queue file of the daemon" default:"capollama-queue.jsonl"`
+	All   bool   `arg:"--all" help:"List the finished jobs too"`
+}
+
+// statusArgs are the flags of "capollama status"
+type statusArgs struct {
+	Queue string `arg:"--queue" help:"The queue file of the daemon" default:"capollama-queue.jsonl"`
+}
+
+// runQueue handles "capollama queue [--all]", it lists the jobs in the order
+// the daemon takes them
+func runQueue(cmdline []string) {
+	var a queueArgs
+	p := newParser(appName+" queue", &a)
+	p.MustParse(cmdline)
+	q, err := readQueue(a.Queue)
+	if err != nil {
+		log.Fatalf("Could not read queue: %v", err)
+	}
+	order := map[string]int{"running": 0, "pending": 1, "failed": 2, "done": 3}
+	var jobs []*queueJob
+	for _, job := range q.jobs {
+		if a.All || job.Status == "pending" || job.Status == "running" {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if order[jobs[i].Status] != order[jobs[j].Status] {
+			return order[jobs[i].Status] < order[jobs[j].Status]
+		}
+		if jobs[i].Status == "pending" {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return false
+	})
+	if len(jobs) == 0 {
+		fmt.Println("The queue is empty")
+		return
+	}
+	fmt.Printf("%5s  %-8s %8s  %s\n", "ID", "STATUS", "PRIORITY", "PATH")
+	for _, job := range jobs {
+		line := fmt.Sprintf("%5d  %-8s %8d  %s", job.ID, job.Status, job.Priority, job.Path)
+		switch job.Status {
+		case "done":
+			line += fmt.Sprintf(" (%d captioned, %d failed)", job.Captioned, job.Failed)
+		case "failed":
+			line += " (" + job.Error + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// runStatus handles "capollama status", it shows the daemon, the counts of
+// the jobs and the running job
+func runStatus(cmdline []string) {
+	var a statusArgs
+	p := newParser(appName+" status", &a)
+	p.MustParse(cmdline)
+	q, err := readQueue(a.Queue)
+	if err != nil {
+		log.Fatalf("Could not read queue: %v", err)
+	}
+	if q.daemon == nil {
+		fmt.Printf("No daemon has used %s yet\n", a.Queue)
+	} else {
+		fmt.Printf("Daemon: pid %d, started %s\n", q.daemon.PID, q.daemon.Time.Format(time.DateTime))
+	}
+	counts := map[string]int{}
+	var captioned int
+	for _, job := range q.jobs {
+		counts[job.Status]++
+		captioned += job.Captioned
+		if job.Status == "running" {
+			fmt.Printf("Running: job %d since %s: %s\n", job.ID, job.Started.Format(time.DateTime), job.Path)
+		}
+	}
+	fmt.Printf("Jobs: %d pending, %d running, %d done, %d failed (%d images captioned)\n",
+		counts["pending"], counts["running"], counts["done"], counts["failed"], captioned)
+}
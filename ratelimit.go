@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter throttles the requests to the model, shared by all workers
+type rateLimiter struct {
+	mu       sync.Mutex
+	rpm      int
+	starts   []time.Time
+	inflight chan struct{}
+}
+
+// limiter is configured from --rpm and --max-inflight
+var limiter = newRateLimiter(0, 0)
+
+// newRateLimiter allows rpm requests per minute and maxInflight requests at
+// the same time, 0 means unlimited
+func newRateLimiter(rpm int, maxInflight int) *rateLimiter {
+	l := &rateLimiter{rpm: rpm}
+	if maxInflight > 0 {
+		l.inflight = make(chan struct{}, maxInflight)
+	}
+	return l
+}
+
+// acquire blocks until a request may be sent
+func (l *rateLimiter) acquire() {
+	if l.inflight != nil {
+		l.inflight <- struct{}{}
+	}
+	if l.rpm <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		// sliding window over the last minute
+		now := time.Now()
+		for len(l.starts) > 0 && now.Sub(l.starts[0]) >= time.Minute {
+			l.starts = l.starts[1:]
+		}
+		if len(l.starts) < l.rpm {
+			l.starts = append(l.starts, now)
+			return
+		}
+		wait := l.starts[0].Add(time.Minute).Sub(now)
+		l.mu.Unlock()
+		time.Sleep(wait)
+		l.mu.Lock()
+	}
+}
+
+// release must be called after each acquire when the request is done
+func (l *rateLimiter) release() {
+	if l.inflight != nil {
+		<-l.inflight
+	}
+}
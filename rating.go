@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var safetyRatings = []string{"safe", "suggestive", "explicit"}
+
+const ratingPrompt = "Rate this image for filtering a dataset: safe (no nudity and nothing sexual), suggestive (revealing clothing, underwear or sexual poses without nudity) " +
+	"or explicit (nudity or sexual acts). Answer only with JSON in the form {\"rating\": \"safe\"}."
+
+// RateImage asks the model for the safety rating of the image
+func RateImage(ol *hostPool, args args, usage *tokenUsage, imgData []byte) (string, error) {
+	answer, err := CaptionImage(ol, args, usage, ratingPrompt, "json", imgData)
+	if err != nil {
+		return "", err
+	}
+	var rated struct {
+		Rating string `json:"rating"`
+	}
+	err = json.Unmarshal([]byte(answer), &rated)
+	if err != nil {
+		return "", fmt.Errorf("invalid rating answer %q: %w", answer, err)
+	}
+	rating := strings.ToLower(strings.TrimSpace(rated.Rating))
+	if !contains(safetyRatings, rating) {
+		return "", fmt.Errorf("unknown rating %q", rated.Rating)
+	}
+	return rating, nil
+}
+
+// moveImage moves the image and its caption files into the folder, keeping
+// the folders below the root, and returns the new path of the image
+func moveImage(args args, path string, root string, folder string) (string, error) {
+	rel := filepath.Base(path)
+	if root != "" {
+		var err error
+		rel, err = filepath.Rel(root, path)
+		if err != nil {
+			return "", err
+		}
+	}
+	dest := filepath.Join(folder, rel)
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range outputFiles(args, path) {
+		err = os.Rename(file, filepath.Join(filepath.Dir(dest), filepath.Base(file)))
+		if err != nil {
+			return "", fmt.Errorf("could not move %s: %w", file, err)
+		}
+	}
+	err = os.Rename(path, dest)
+	if err != nil {
+		return "", fmt.Errorf("could not move %s: %w", path, err)
+	}
+	logVerbose("Moved %s to %s", logPath(path), dest)
+	return dest, nil
+}
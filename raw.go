@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rawExtensions are the camera RAW formats, they are captioned by the JPEG
+// preview that the camera embeds in the file
+var rawExtensions = []string{".arw", ".cr2", ".cr3", ".dng", ".nef", ".nrw", ".orf", ".pef", ".raf", ".rw2", ".srw"}
+
+// isRawFile checks if the file has the extension of a camera RAW format
+func isRawFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, raw := range rawExtensions {
+		if ext == raw {
+			return true
+		}
+	}
+	return false
+}
+
+// readImage reads the image file, for RAW files it returns the embedded preview
+func readImage(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !isRawFile(path) {
+		return data, err
+	}
+	preview, err := rawPreview(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptImage, err)
+	}
+	return preview, nil
+}
+
+// rawPreview finds the largest JPEG in the RAW file. All formats embed at least
+// one baseline JPEG, the sensor data itself is lossless JPEG or not JPEG at all
+// and can't be decoded by image/jpeg, so it is never picked.
+func rawPreview(data []byte) ([]byte, error) {
+	best, bestArea := -1, 0
+	for pos := 0; ; pos++ {
+		i := bytes.Index(data[pos:], []byte{0xFF, 0xD8, 0xFF})
+		if i < 0 {
+			break
+		}
+		pos += i
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data[pos:]))
+		if err == nil && cfg.Width*cfg.Height > bestArea {
+			best, bestArea = pos, cfg.Width*cfg.Height
+		}
+	}
+	if best < 0 {
+		return nil, errors.New("no embedded preview")
+	}
+	// the decoder reads ahead, so the preview ends at the last EOI it has read
+	r := &countingReader{r: bytes.NewReader(data[best:])}
+	_, err := jpeg.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("broken embedded preview: %w", err)
+	}
+	end := bytes.LastIndex(data[best:best+r.n], []byte{0xFF, 0xD9})
+	if end < 0 {
+		return nil, errors.New("embedded preview without end")
+	}
+	preview := data[best : best+end+2]
+
+	// the preview of a TIFF based RAW usually has no orientation of its own
+	o := tiffOrientation(data)
+	if o > 1 && exifOrientation(preview) == 1 {
+		src, err := jpeg.Decode(bytes.NewReader(preview))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, orient(toNRGBA(src), o), &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return preview, nil
+}
+
+// countingReader counts the bytes that were read
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+)
+
+// readAhead prepares the next images (--read-ahead) while the workers wait
+// for the model, so the backend doesn't wait for reading and preprocessing
+type readAhead struct {
+	mu      sync.Mutex
+	loads   map[string]*preload
+	started map[string]bool // the images that were taken or are prepared
+	slots   chan struct{}   // the prepared images that are kept in memory
+}
+
+// preload is an image that is prepared in the background
+type preload struct {
+	done   chan struct{}
+	prompt string
+	images [][]byte
+	err    error
+}
+
+var prefetch *readAhead // nil without --read-ahead
+
+func newReadAhead(size int) *readAhead {
+	return &readAhead{loads: map[string]*preload{}, started: map[string]bool{}, slots: make(chan struct{}, size)}
+}
+
+// start prepares the images in the order the workers take them. PDFs have
+// their pages prepared by processPDF and are not read ahead.
+func (r *readAhead) start(args args, todo []string, root string) {
+	if r == nil {
+		return
+	}
+	// the scans of watch start again with the images of the scan
+	r.mu.Lock()
+	r.started = map[string]bool{}
+	r.mu.Unlock()
+	go func() {
+		for _, path := range todo {
+			if isPDFFile(path) {
+				continue
+			}
+			r.slots <- struct{}{}
+			r.mu.Lock()
+			if r.started[path] {
+				// a worker was faster
+				r.mu.Unlock()
+				<-r.slots
+				continue
+			}
+			p := &preload{done: make(chan struct{})}
+			r.loads[path] = p
+			r.started[path] = true
+			r.mu.Unlock()
+
+			imageArgs, err := overrides.apply(args, path, root)
+			if err == nil {
+				logDebug("Reading ahead %s", logPath(path))
+				p.prompt, p.images, err = loadImage(imageArgs, path)
+			}
+			p.err = err
+			close(p.done)
+		}
+	}()
+}
+
+// load is loadImage for the worker, with the prepared image if there is one
+func (r *readAhead) load(args args, path string) (string, [][]byte, error) {
+	if r == nil {
+		return loadImage(args, path)
+	}
+	r.mu.Lock()
+	p := r.loads[path]
+	delete(r.loads, path)
+	r.started[path] = true
+	r.mu.Unlock()
+	if p == nil {
+		return loadImage(args, path)
+	}
+	<-p.done
+	<-r.slots
+	return p.prompt, p.images, p.err
+}
+
+// drop frees the prepared image if the worker didn't use it, like for an
+// image that needs no caption
+func (r *readAhead) drop(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	p := r.loads[path]
+	delete(r.loads, path)
+	r.mu.Unlock()
+	if p != nil {
+		<-p.done
+		<-r.slots
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+)
+
+// referencePrompt is put before the prompts of the requests with --reference-image
+const referencePrompt = "The first image is only a reference for the style. Answer about the second image, not about the reference."
+
+var referenceImage []byte // nil without --reference-image
+
+// loadReference reads and preprocesses --reference-image once for all requests
+func loadReference(args args) ([]byte, error) {
+	data, err := readImage(args.ReferenceImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	err = validateImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return Preprocess(data, args.steps)
+}
+
+// withReference puts the reference image before the images of a request and
+// explains its role in the prompt, requests without images stay as they are
+func withReference(args args, prompt string, images [][]byte) (string, [][]byte) {
+	if referenceImage == nil || len(images) == 0 {
+		return prompt, images
+	}
+	return args.ReferencePrompt + "\n" + prompt, append([][]byte{referenceImage}, images...)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+const refinePrompt = "This caption was written for the image with the instruction %q:\n\n%s\n\n" +
+	"Check the caption against the image. Correct the details that are wrong or not in the image and add important details that are missing. " +
+	"Keep the style, the length and the language. Answer only with the corrected caption."
+
+// refineTurn follows the caption in the chat with --use-chat-api
+const refineTurn = "Check your caption against the image. Correct the details that are wrong or not in the image and add important details that are missing. " +
+	"Keep the style, the length and the language. Answer only with the corrected caption."
+
+// refineAnswer asks the model --refine times to verify its caption against
+// the image and uses the last revision, it stops early when nothing changes.
+// With the chat API the check is the next turn after the prompt and the
+// caption, the generate API gets the caption in a new prompt.
+func refineAnswer(ol *hostPool, args args, usage *tokenUsage, prompt string, text string, images ...[]byte) (string, error) {
+	for round := 1; round <= args.Refine; round++ {
+		check := args
+		critique := fmt.Sprintf(refinePrompt, args.Prompt, text) + promptHints(args)
+		if args.UseChatAPI {
+			check.history = []api.Message{{Role: "user", Content: prompt}, {Role: "assistant", Content: text}}
+			critique = refineTurn + promptHints(args)
+		}
+		answer, err := askInLanguage(ol, check, usage, critique, images...)
+		if err != nil {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" || answer == text {
+			logVerbose("Refinement round %d kept the caption", round)
+			break
+		}
+		logVerbose("Refinement round %d: %q", round, answer)
+		text = answer
+	}
+	return text, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the longest side of the thumbnails in the gallery
+const thumbnailSize = 256
+
+//go:embed report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHTML))
+
+// reportEntry is an image in the gallery of --report
+type reportEntry struct {
+	Path      string
+	Caption   string
+	Extras    []result
+	Model     string
+	Prompt    string
+	Thumbnail template.URL
+	Compared  []modelCaption // the captions of every model when models are compared
+	file      string
+}
+
+// galleryReport collects the captioned images of the run for --report
+type galleryReport struct {
+	mu      sync.Mutex
+	entries []reportEntry
+}
+
+// report is nil without --report
+var report *galleryReport
+
+// add records a captioned image, args are the ones of the image (with its overrides)
+func (r *galleryReport) add(args args, path string, root string, caption string, extras []result) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, reportEntry{
+		Path:    strings.TrimPrefix(path, root),
+		Caption: caption,
+		Extras:  extras,
+		Model:   args.Model,
+		Prompt:  args.Prompt,
+		file:    path,
+	})
+}
+
+// write creates a self-contained HTML page with the thumbnails embedded, or
+// a Markdown report if the file ends with .md
+func (r *galleryReport) write(file string, args args) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		return r.entries[i].Path < r.entries[j].Path
+	})
+	model := strings.Join(args.Models, ", ")
+	if len(args.Models) > 1 {
+		r.entries = compareEntries(r.entries, model)
+	}
+	if strings.EqualFold(filepath.Ext(file), ".md") {
+		return r.writeMarkdown(file, args, model)
+	}
+	// the thumbnails are made at the end, so they don't stay in memory during the run
+	for i := range r.entries {
+		thumb, err := thumbnail(r.entries[i].file)
+		if err != nil {
+			logVerbose("No thumbnail for %s: %v", r.entries[i].file, err)
+		}
+		r.entries[i].Thumbnail = thumb
+	}
+
+	var buf bytes.Buffer
+	err := reportTemplate.Execute(&buf, map[string]any{
+		"Created": time.Now().Format("2006-01-02 15:04"),
+		"Path":    args.Path,
+		"Model":   model,
+		"Prompt":  args.Prompt,
+		"System":  args.System,
+		"Entries": r.entries,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// writeMarkdown lists the images grouped by folder with the summary of the
+// run at the top, the image links are relative to the report
+func (r *galleryReport) writeMarkdown(file string, args args, model string) error {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("# Captions of " + args.Path + "\n\n")
+	for _, line := range stats.summary() {
+		b.WriteString("- " + line + "\n")
+	}
+	fmt.Fprintf(&b, "- Model: %s\n- Prompt: %s\n", model, markdownText(args.Prompt))
+
+	folder := ""
+	for i, entry := range r.entries {
+		if f := filepath.ToSlash(filepath.Dir(entry.Path)); i == 0 || f != folder {
+			folder = f
+			b.WriteString("\n## " + folder + "\n")
+		}
+		link, err := filepath.Abs(entry.file)
+		if err == nil {
+			link, err = filepath.Rel(dir, link)
+		}
+		if err != nil {
+			return err
+		}
+		link = filepath.ToSlash(link)
+		if strings.ContainsAny(link, " ()") {
+			link = "<" + link + ">"
+		}
+		fmt.Fprintf(&b, "\n![%s](%s)\n", filepath.Base(entry.file), link)
+		if entry.Caption != "" {
+			b.WriteString("\n" + markdownText(entry.Caption) + "\n")
+		}
+		for _, compared := range entry.Compared {
+			fmt.Fprintf(&b, "\n**%s:** %s\n", markdownText(compared.Model), markdownText(compared.Caption))
+		}
+		for _, extra := range entry.Extras {
+			fmt.Fprintf(&b, "\n*%s:* %s\n", extra.Suffix, markdownText(extra.Caption))
+		}
+		if entry.Model != model || entry.Prompt != args.Prompt {
+			fmt.Fprintf(&b, "\n*%s: %s*\n", entry.Model, markdownText(entry.Prompt))
+		}
+	}
+	return os.WriteFile(file, []byte(b.String()), 0644)
+}
+
+// markdownText keeps a caption on one line and escapes what would be markup
+func markdownText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, "[", `\[`, "#", `\#`).Replace(text)
+}
+
+// thumbnail returns a small JPEG of the image as data URL
+func thumbnail(path string) (template.URL, error) {
+	data, err := readImage(path)
+	if err != nil {
+		return "", err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	img := resizeToFit(orient(toNRGBA(src), exifOrientation(data)), thumbnailSize)
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75})
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
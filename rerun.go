@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+)
+
+type rerunArgs struct {
+	Manifest string `arg:"positional,required" help:"Run manifest that was written with --summary"`
+	Path     string `arg:"positional" help:"Process this path instead of the recorded one"`
+	Summary  string `arg:"--summary" help:"Write the statistics and configuration of this run as JSON to this file"`
+}
+
+func (rerunArgs) Description() string {
+	return "Runs capollama again with exactly the configuration recorded in a run manifest\n"
+}
+
+// parseRerun handles "capollama rerun run.json [PATH]" and returns the recorded args
+func parseRerun(cmdline []string) (*arg.Parser, args) {
+	var ra rerunArgs
+	p := newParser(appName+" rerun", &ra)
+	p.MustParse(cmdline)
+
+	data, err := os.ReadFile(ra.Manifest)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	// the manifest is read over the defaults, so the options it does not
+	// record (like those added in later versions) keep their default values
+	_, defaults := parseCaption(appName, nil)
+	var manifest struct {
+		Config *json.RawMessage `json:"config"`
+	}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		p.Fail(fmt.Sprintf("invalid run manifest: %v", err))
+	}
+	if manifest.Config == nil {
+		p.Fail("the run manifest has no recorded configuration")
+	}
+	config := defaults
+	err = json.Unmarshal(*manifest.Config, &config)
+	if err != nil {
+		p.Fail(fmt.Sprintf("invalid run manifest: %v", err))
+	}
+
+	if ra.Path != "" {
+		config.Path = ra.Path
+		config.FilesFrom = ""
+	}
+	// never overwrite the manifest we are rerunning by accident
+	config.Summary = ra.Summary
+	return p, config
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+var reviewImageModes = []string{"auto", "kitty", "iterm", "none"}
+
+// the longest side of the images shown in the terminal
+const reviewImageSize = 512
+
+// reviewArgs are the flags of "capollama review", the flags of caption are
+// used when an image is captioned again
+type reviewArgs struct {
+	args
+	Decisions string `arg:"--decisions" help:"JSON lines file that records the decisions, images in it are not shown again" default:"review.jsonl"`
+	Images    string `arg:"--images" help:"How the images are shown: auto, kitty (kitty graphics protocol), iterm (inline images of iTerm2 and WezTerm) or none (only the path)" default:"auto"`
+}
+
+func (reviewArgs) Description() string {
+	return "Steps through the captioned images to accept, edit or regenerate their captions\n"
+}
+
+func (reviewArgs) Epilogue() string {
+	return ""
+}
+
+// parseReview handles "capollama review [--decisions review.jsonl] PATH"
+func parseReview(cmdline []string) (*arg.Parser, args) {
+	ra := reviewArgs{args: args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}}
+	p := newParser(appName+" review", &ra)
+	p.MustParse(cmdline)
+	if !contains(reviewImageModes, ra.Images) {
+		p.Fail(fmt.Sprintf("unknown image mode %q", ra.Images))
+	}
+	if ra.Mode == "dual" || len(ra.ExtraPrompts) > 0 || ra.PromptsFile != "" {
+		p.Fail("review only works for single captions")
+	}
+	ra.args.reviewFile = ra.Decisions
+	ra.args.reviewImages = ra.Images
+	if ra.Images == "auto" {
+		ra.args.reviewImages = terminalImages()
+	}
+	return p, ra.args
+}
+
+// terminalImages detects the image protocol of the terminal
+func terminalImages() string {
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "iterm"
+	}
+	return "none"
+}
+
+// reviewDecision is a line of the --decisions file
+type reviewDecision struct {
+	Path     string    `json:"path"`
+	Decision string    `json:"decision"` // accepted, edited or regenerated
+	Caption  string    `json:"caption"`
+	Prompt   string    `json:"prompt,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func loadDecisions(file string) (map[string]bool, error) {
+	reviewed := map[string]bool{}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return reviewed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var d reviewDecision
+		err = json.Unmarshal(scanner.Bytes(), &d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decision in %s: %w", file, err)
+		}
+		reviewed[d.Path] = true
+	}
+	return reviewed, scanner.Err()
+}
+
+// reviewImages shows the captioned images that were not reviewed yet and
+// asks what to do with each caption
+func reviewImages(ol *hostPool, args args) error {
+	reviewed, err := loadDecisions(args.reviewFile)
+	if err != nil {
+		return err
+	}
+	var images []imageFile
+	var root string
+	opts := walkOptions{Order: args.Order, Seed: args.Seed}
+	if args.FilesFrom != "" {
+		images, err = CollectFileList(args.FilesFrom, opts)
+	} else {
+		images, root, err = CollectImages(args.Path, opts)
+	}
+	if err != nil {
+		return err
+	}
+	var todo []string
+	for _, image := range images {
+		if fileExists(captionFile(image.Path)) && !reviewed[image.Path] {
+			todo = append(todo, image.Path)
+		}
+	}
+	if len(todo) == 0 {
+		logInfo("No captions to review")
+		return nil
+	}
+
+	out, err := os.OpenFile(args.reviewFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	enc := json.NewEncoder(out)
+	in := bufio.NewReader(os.Stdin)
+
+	for i, path := range todo {
+		caption, err := readCaption(captionFile(path))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(todo), path)
+		err = showImage(os.Stdout, args.reviewImages, path)
+		if err != nil {
+			logVerbose("Can't show %s: %v", path, err)
+		}
+
+		decision := reviewDecision{Path: path, Decision: "accepted"}
+	ask:
+		for {
+			fmt.Printf("\n%s\n\n[a]ccept, [e]dit, [r]egenerate, [s]kip or [q]uit? ", caption)
+			answer, err := readLine(in)
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(answer) {
+			case "a", "":
+				break ask
+			case "e":
+				fmt.Print("New caption (empty keeps it): ")
+				edited, err := readLine(in)
+				if err != nil {
+					return err
+				}
+				if edited != "" {
+					caption = edited
+					decision.Decision = "edited"
+				}
+			case "r":
+				fmt.Print("Prompt (empty for the same): ")
+				prompt, err := readLine(in)
+				if err != nil {
+					return err
+				}
+				regenerated, usedPrompt, err := regenerateCaption(ol, args, path, root, prompt)
+				if err != nil {
+					logError("Could not regenerate %s: %v", path, err)
+					continue
+				}
+				caption = regenerated
+				decision.Decision = "regenerated"
+				decision.Prompt = usedPrompt
+			case "s":
+				decision.Decision = ""
+				break ask
+			case "q":
+				return nil
+			}
+		}
+		if decision.Decision == "" {
+			continue
+		}
+
+		if decision.Decision != "accepted" && !args.DryRun {
+			// the .json keeps the same caption, like with serve
+			err = writeCaption(path, caption)
+			if err != nil {
+				return fmt.Errorf("could not write file: %w", err)
+			}
+		}
+		decision.Caption = caption
+		decision.Time = time.Now()
+		err = enc.Encode(decision)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLine reads an answer, the end of the input counts as quit
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "q", nil
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// regenerateCaption captions the image again, optionally with another prompt
+func regenerateCaption(ol *hostPool, args args, path string, root string, prompt string) (string, string, error) {
+	args, err := overrides.apply(args, path, root)
+	if err != nil {
+		return "", "", err
+	}
+	if prompt != "" {
+		args.Prompt = prompt
+	}
+	fullPrompt, images, err := loadImage(args, path)
+	if err != nil {
+		return "", "", err
+	}
+	var usage tokenUsage
+	caption, err := generateCaption(ol, args, &usage, path, fullPrompt, images...)
+	return caption, args.Prompt, err
+}
+
+// showImage draws the image with the graphics protocol of the terminal
+func showImage(w io.Writer, mode string, path string) error {
+	if mode == "none" {
+		return nil
+	}
+	data, err := readImage(path)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, resizeToFit(orient(toNRGBA(src), exifOrientation(data)), reviewImageSize))
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	if mode == "iterm" {
+		_, err = fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", buf.Len(), encoded)
+		return err
+	}
+	// kitty wants the data in chunks of at most 4096 bytes, only the first
+	// one has the keys of the image
+	keys := "f=100,a=T,"
+	for len(encoded) > 0 {
+		chunk := encoded[:min(len(encoded), 4096)]
+		encoded = encoded[len(chunk):]
+		more := 0
+		if len(encoded) > 0 {
+			more = 1
+		}
+		_, err = fmt.Fprintf(w, "\x1b_G%sm=%d;%s\x1b\\", keys, more, chunk)
+		if err != nil {
+			return err
+		}
+		keys = ""
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
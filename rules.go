@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+)
+
+// rulesFile is the TOML file of --rules
+type rulesFile struct {
+	Strip   []string `toml:"strip"`
+	Ban     []string `toml:"ban"`
+	Replace []struct {
+		Pattern string `toml:"pattern"`
+		With    string `toml:"with"`
+	} `toml:"replace"`
+}
+
+type replaceRule struct {
+	re   *regexp.Regexp
+	with string
+}
+
+// wordRule removes a phrase where it stands as whole words. The first group
+// of the pattern is the phrase, the runes around it are checked in remove as
+// \b only knows ASCII letters.
+type wordRule struct {
+	re    *regexp.Regexp
+	start bool // the phrase starts with a letter, a digit or _
+	end   bool // and ends with one
+}
+
+// ruleSet cleans up the verbal tics of the models before the captions are written
+type ruleSet struct {
+	replace []replaceRule
+	strip   []wordRule
+	ban     []wordRule
+}
+
+var rules *ruleSet
+
+// the whitespace and punctuation that is left over after removing text
+var (
+	spacesRE      = regexp.MustCompile(`[ \t]{2,}`)
+	spacePunctRE  = regexp.MustCompile(`\s+([,.;:!?])`)
+	doublePunctRE = regexp.MustCompile(`([,;:])\s*([,.;:!?])`)
+)
+
+func loadRules(file string) (*ruleSet, error) {
+	var f rulesFile
+	md, err := toml.DecodeFile(file, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules %s: %w", file, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown setting %q in %s", undecoded[0].String(), file)
+	}
+
+	r := &ruleSet{}
+	for _, rule := range f.Replace {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", rule.Pattern, file, err)
+		}
+		r.replace = append(r.replace, replaceRule{re: re, with: rule.With})
+	}
+	// phrases take a following comma with them ("Possibly, a dog" becomes "a dog")
+	for _, phrase := range f.Strip {
+		if strings.TrimSpace(phrase) != "" {
+			r.strip = append(r.strip, newWordRule(phrase, `,?\s*`))
+		}
+	}
+	for _, word := range f.Ban {
+		if strings.TrimSpace(word) != "" {
+			r.ban = append(r.ban, newWordRule(word, ""))
+		}
+	}
+	return r, nil
+}
+
+// newWordRule matches the text literally and case-insensitively, followed by the suffix pattern
+func newWordRule(text string, suffix string) wordRule {
+	text = strings.TrimSpace(text)
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return wordRule{
+		re:    regexp.MustCompile(`(?i)(` + regexp.QuoteMeta(text) + `)` + suffix),
+		start: isWordRune(first),
+		end:   isWordRune(last),
+	}
+}
+
+// remove deletes the matches that are not part of a longer word
+func (w wordRule) remove(text string) string {
+	var b strings.Builder
+	pos := 0
+	for {
+		m := w.re.FindStringSubmatchIndex(text[pos:])
+		if m == nil {
+			break
+		}
+		start, phraseEnd, end := pos+m[0], pos+m[3], pos+m[1]
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[phraseEnd:])
+		if w.start && start > 0 && isWordRune(before) || w.end && phraseEnd < len(text) && isWordRune(after) {
+			// try again after the first rune of the match
+			_, size := utf8.DecodeRuneInString(text[start:])
+			b.WriteString(text[pos : start+size])
+			pos = start + size
+			continue
+		}
+		b.WriteString(text[pos:start])
+		pos = end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// apply runs the replacements, strips the phrases, removes the banned words
+// and tidies up the spaces and punctuation that were left behind
+func (r *ruleSet) apply(text string) string {
+	if r == nil {
+		return text
+	}
+	original := text
+	for _, rule := range r.replace {
+		text = rule.re.ReplaceAllString(text, rule.with)
+	}
+	for _, rule := range r.strip {
+		text = rule.remove(text)
+	}
+	for _, rule := range r.ban {
+		text = rule.remove(text)
+	}
+	if text == original {
+		return text
+	}
+	text = spacesRE.ReplaceAllString(text, " ")
+	text = spacePunctRE.ReplaceAllString(text, "$1")
+	text = doublePunctRE.ReplaceAllString(text, "$2")
+	text = strings.TrimLeft(strings.TrimSpace(text), ",;: ")
+	// keep the capital letter at the start of the sentence
+	first, _ := utf8.DecodeRuneInString(original)
+	if unicode.IsUpper(first) && text != "" {
+		head, size := utf8.DecodeRuneInString(text)
+		text = string(unicode.ToUpper(head)) + text[size:]
+	}
+	return text
+}
+
+// rewrite applies the rules and shows what they changed with --dry-run
+func (r *ruleSet) rewrite(args args, path string, text string) string {
+	changed := r.apply(text)
+	if args.DryRun && changed != text {
+		logInfo("Rules changed %s:\n- %s\n+ %s", path, text, changed)
+	}
+	return changed
+}
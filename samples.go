@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const candidatesSuffix = ".candidates.json"
+
+const judgePrompt = "These are %d candidate captions for the image, written for the instruction %q:\n\n%s\n" +
+	"Pick the best candidate, the one that is most accurate and complete without inventing details, or merge the best parts into one caption. " +
+	"Keep the style and the language. Answer only with the final caption."
+
+// candidateRecord is the .candidates.json of --candidates
+type candidateRecord struct {
+	Judge      string   `json:"judge"`
+	Candidates []string `json:"candidates"`
+	Winner     string   `json:"winner"`
+}
+
+// bestOfSamples asks the prompt, with --samples it generates the candidates
+// at a higher temperature and lets the judge pick or merge the winner
+func bestOfSamples(ol *hostPool, args args, usage *tokenUsage, path string, prompt string, images ...[]byte) (string, error) {
+	if args.Samples < 2 {
+		return askInLanguage(ol, args, usage, prompt, images...)
+	}
+	var candidates []string
+	var list strings.Builder
+	for i := 1; i <= args.Samples; i++ {
+		sampler := args
+		sampler.sample = i
+		answer, err := askInLanguage(ol, sampler, usage, prompt, images...)
+		if err != nil {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		candidates = append(candidates, answer)
+		fmt.Fprintf(&list, "%d. %s\n", i, answer)
+	}
+
+	judge := args
+	judge.System = ""
+	if args.Judge != "" {
+		judge.Model = args.Judge
+	}
+	judgeImages := images
+	if args.JudgeTextOnly {
+		judgeImages = nil
+	}
+	logVerbose("Judging %d candidates of %s with %s", len(candidates), path, judge.Model)
+	winner, err := askInLanguage(ol, judge, usage, fmt.Sprintf(judgePrompt, len(candidates), args.Prompt, list.String())+promptHints(args), judgeImages...)
+	if err != nil {
+		return "", err
+	}
+	winner = strings.TrimSpace(winner)
+	if args.Candidates && !args.DryRun {
+		data, err := json.MarshalIndent(candidateRecord{Judge: judge.Model, Candidates: candidates, Winner: winner}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		err = os.WriteFile(outputFile(path, candidatesSuffix), data, 0644)
+		if err != nil {
+			return "", fmt.Errorf("could not write file: %w", err)
+		}
+	}
+	return winner, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alexflint/go-arg"
+)
+
+//go:embed serve.html
+var serveHTML []byte
+
+// serveArgs are the flags of "capollama serve", the flags of caption are
+// used when an image is captioned again
+type serveArgs struct {
+	args
+	Listen string `arg:"--listen" help:"Address of the HTTP server" default:"127.0.0.1:8080"`
+	UI     bool   `arg:"--ui" help:"Serve the web app for browsing and editing the captions (otherwise only the API is served)"`
+}
+
+func (serveArgs) Description() string {
+	return "Serves the images and captions of PATH over HTTP for browsing, editing and regenerating\n"
+}
+
+func (serveArgs) Epilogue() string {
+	return ""
+}
+
+// parseServe handles "capollama serve [--ui] [--listen ADDR] PATH"
+func parseServe(cmdline []string) (*arg.Parser, args) {
+	sa := serveArgs{args: args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}}
+	p := newParser(appName+" serve", &sa)
+	p.MustParse(cmdline)
+	if sa.FilesFrom != "" || sa.Mode == "dual" || len(sa.ExtraPrompts) > 0 || sa.PromptsFile != "" {
+		p.Fail("serve only works for single captions of PATH")
+	}
+	sa.args.serveAddr = sa.Listen
+	sa.args.serveUI = sa.UI
+	return p, sa.args
+}
+
+// captionServer is the HTTP API for the captions of the images below the root
+type captionServer struct {
+	ol   *hostPool
+	args args
+	mu   sync.Mutex // one edit or regeneration at a time
+
+	indexMu sync.Mutex
+	index   map[string]string // the paths of the API and their images, nil until the first list
+	root    string
+}
+
+// serveItem is an image in the list of the API
+type serveItem struct {
+	Path    string `json:"path"` // relative to PATH
+	Caption string `json:"caption"`
+	Exists  bool   `json:"exists"`
+}
+
+type captionUpdate struct {
+	Path    string `json:"path"`
+	Caption string `json:"caption"`
+	Prompt  string `json:"prompt,omitempty"`
+}
+
+func serveCaptions(ol *hostPool, args args) error {
+	s := &captionServer{ol: ol, args: args}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/images", s.handleImages)
+	mux.HandleFunc("/api/caption", s.handleCaption)
+	mux.HandleFunc("/api/regenerate", s.handleRegenerate)
+	mux.HandleFunc("/image/", s.handleImage)
+	mux.HandleFunc("/metrics", serveMetrics)
+	if args.serveUI {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(serveHTML)
+		})
+	}
+	logInfo("Serving %s on http://%s/", args.Path, args.serveAddr)
+	return http.ListenAndServe(args.serveAddr, s.checkHost(mux))
+}
+
+// checkHost rejects the requests for other host names, so a web page can't
+// reach the API through DNS rebinding
+func (s *captionServer) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			http.Error(w, "unknown host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost checks a host of a request against the listen address: it is
+// the address itself, localhost or an IP address with the same port
+func (s *captionServer) allowedHost(hostport string) bool {
+	listenHost, listenPort, err := net.SplitHostPort(s.args.serveAddr)
+	if err != nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// the default port of http
+		host, port = hostport, "80"
+	}
+	if port != listenPort {
+		return false
+	}
+	host = strings.Trim(host, "[]")
+	if host == listenHost || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	// with an address that listens on all interfaces, the IP addresses of the machine
+	ip, listenIP := net.ParseIP(host), net.ParseIP(listenHost)
+	return ip != nil && (listenHost == "" || listenIP != nil && (listenIP.IsUnspecified() || listenIP.IsLoopback() && ip.IsLoopback()))
+}
+
+// images lists the images of PATH again, so new images show up, and updates
+// the index of the paths
+func (s *captionServer) images() ([]imageFile, string, error) {
+	images, root, err := CollectImages(s.args.Path, walkOptions{Order: s.args.Order, Seed: s.args.Seed})
+	if err != nil {
+		return nil, "", err
+	}
+	index := make(map[string]string, len(images))
+	for _, image := range images {
+		index[servePath(image.Path, root)] = image.Path
+	}
+	s.indexMu.Lock()
+	s.index, s.root = index, root
+	s.indexMu.Unlock()
+	return images, root, nil
+}
+
+// resolve maps a path of the API to an image below PATH, other files can't be
+// accessed. The images are in the index of the last list.
+func (s *captionServer) resolve(rel string) (string, string, error) {
+	s.indexMu.Lock()
+	index, root := s.index, s.root
+	s.indexMu.Unlock()
+	if index == nil {
+		var err error
+		_, root, err = s.images()
+		if err != nil {
+			return "", "", err
+		}
+		s.indexMu.Lock()
+		index = s.index
+		s.indexMu.Unlock()
+	}
+	path, ok := index[strings.TrimPrefix(rel, "/")]
+	if !ok {
+		return "", "", os.ErrNotExist
+	}
+	return path, root, nil
+}
+
+// servePath is the path of the image in the API
+func servePath(path string, root string) string {
+	return strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(path, root)), "/")
+}
+
+func (s *captionServer) handleImages(w http.ResponseWriter, r *http.Request) {
+	images, root, err := s.images()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := []serveItem{}
+	for _, image := range images {
+		caption, err := readCaption(captionFile(image.Path))
+		items = append(items, serveItem{
+			Path:    servePath(image.Path, root),
+			Caption: caption,
+			Exists:  err == nil,
+		})
+	}
+	writeJSON(w, items)
+}
+
+func (s *captionServer) handleImage(w http.ResponseWriter, r *http.Request) {
+	path, _, err := s.resolve(strings.TrimPrefix(r.URL.Path, "/image/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if isRawFile(path) {
+		// browsers can't show RAW files
+		data, err := readImage(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(data)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// handleCaption writes an edited caption to the .txt (and the .json if there is one)
+func (s *captionServer) handleCaption(w http.ResponseWriter, r *http.Request) {
+	update, path, _, ok := s.update(w, r)
+	if !ok {
+		return
+	}
+	if s.args.DryRun {
+		http.Error(w, "captions are not written with --dry-run", http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := writeCaption(path, strings.TrimSpace(update.Caption))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logVerbose("Saved the caption of %s", path)
+	writeJSON(w, update)
+}
+
+// handleRegenerate captions the image again and returns the caption without writing it
+func (s *captionServer) handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	update, path, root, ok := s.update(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	caption, prompt, err := regenerateCaption(s.ol, s.args, path, root, update.Prompt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, captionUpdate{Path: update.Path, Caption: caption, Prompt: prompt})
+}
+
+// update reads the body of a POST and resolves its image. The body must be
+// JSON, which browsers only send to other sites after a CORS preflight, and a
+// request of a web page must come from the server itself.
+func (s *captionServer) update(w http.ResponseWriter, r *http.Request) (captionUpdate, string, string, bool) {
+	var update captionUpdate
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return update, "", "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "the body must be application/json", http.StatusUnsupportedMediaType)
+		return update, "", "", false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme != "http" || !s.allowedHost(u.Host) {
+			http.Error(w, "unknown origin", http.StatusForbidden)
+			return update, "", "", false
+		}
+	}
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return update, "", "", false
+	}
+	path, root, err := s.resolve(update.Path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unknown image %q", update.Path), http.StatusNotFound)
+		return update, "", "", false
+	}
+	return update, path, root, true
+}
+
+// writeCaption replaces the caption of the image in the .txt and in the .json
+// with the counts if it exists
+func writeCaption(path string, caption string) error {
+	err := writeOutput(captionFile(path), caption)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(metadataFile(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var meta imageMetadata
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", metadataFile(path), err)
+	}
+	meta.Caption = caption
+	return writeMetadata(path, meta)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shardSpec is --shard K/N, the machine K of N takes the images whose path
+// hash modulo N is K-1
+type shardSpec struct {
+	index int // 1 to count
+	count int // 0 without --shard
+}
+
+func parseShard(s string) (shardSpec, error) {
+	k, n, ok := strings.Cut(s, "/")
+	index, errK := strconv.Atoi(k)
+	count, errN := strconv.Atoi(n)
+	if !ok || errK != nil || errN != nil || count < 1 || index < 1 || index > count {
+		return shardSpec{}, fmt.Errorf("invalid --shard %q, use K/N with K from 1 to N like 2/8", s)
+	}
+	return shardSpec{index: index, count: count}, nil
+}
+
+// keep keeps the images of the shard. The hash is taken of the path relative
+// to PATH, so machines that mount the dataset at other places agree.
+func (s shardSpec) keep(images []imageFile, root string) []imageFile {
+	if s.count <= 1 {
+		return images
+	}
+	var kept []imageFile
+	for _, image := range images {
+		key := image.Path
+		if rel, err := filepath.Rel(root, image.Path); root != "" && err == nil {
+			key = rel
+		}
+		h := fnv.New32a()
+		h.Write([]byte(filepath.ToSlash(key)))
+		if int(h.Sum32()%uint32(s.count)) == s.index-1 {
+			kept = append(kept, image)
+		}
+	}
+	return kept
+}
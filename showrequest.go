@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// showRequest prints the request of the image as it would be sent for
+// --show-request, after .capollama.toml, the folder and the hints were applied
+func showRequest(args args, path string, prompt string, images [][]byte, captionFile string) {
+	request := "generate"
+	system := args.System
+	if args.UseChatAPI {
+		// the chat API has no system prompt
+		request, system = "chat", ""
+	}
+	format := ""
+	if args.Mode == "dual" {
+		prompt += dualFormatHint
+		format = "json"
+	}
+	prompt, images = withReference(args, prompt, images)
+	opts, _ := json.Marshal(options(args))
+	sizes := make([]string, len(images))
+	for i, img := range images {
+		sizes[i] = fmt.Sprintf("%d bytes", len(img))
+	}
+	outputs := []string{captionFile}
+	if args.Mode == "dual" {
+		outputs = append(outputs, outputFile(path, dualSuffix))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "== %s\n", path)
+	fmt.Fprintf(&b, "Model:   %s (%s)\n", args.Model, request)
+	if system != "" {
+		fmt.Fprintf(&b, "System:  %s\n", system)
+	}
+	fmt.Fprintf(&b, "Prompt:  %s\n", prompt)
+	fmt.Fprintf(&b, "Options: %s\n", opts)
+	if format != "" {
+		fmt.Fprintf(&b, "Format:  %s\n", format)
+	}
+	fmt.Fprintf(&b, "Images:  %s\n", strings.Join(sizes, ", "))
+	fmt.Fprintf(&b, "Output:  %s\n", strings.Join(outputs, ", "))
+	for _, extra := range args.prompts {
+		fmt.Fprintf(&b, "Extra:   %s %s\n", outputFile(path, extra.Suffix), extra.Prompt+promptHints(args))
+	}
+	fmt.Print(b.String())
+}
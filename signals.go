@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals lets SIGUSR1 toggle verbose output and SIGUSR2 toggle token
+// streaming, so a long running batch can be inspected without restarting it
+func watchSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range c {
+			switch sig {
+			case syscall.SIGUSR1:
+				logMessage(levelInfo, "Verbose output %s", onOff(toggle(&liveVerbose)))
+			case syscall.SIGUSR2:
+				logMessage(levelInfo, "Streaming output %s", onOff(toggle(&liveStream)))
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// watchSignals does nothing because Windows has no SIGUSR1 and SIGUSR2
+func watchSignals() {}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// markdownImage matches ![alt](src "title") and ![alt](<src>)
+var markdownImage = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+["'][^)]*["'])?\s*\)`)
+
+// figureShortcode matches the Hugo figure shortcode {{< figure src="..." >}}
+var figureShortcode = regexp.MustCompile(`\{\{[<%]\s*figure\s+(.*?)\s*/?[%>]\}\}`)
+
+var shortcodeParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|'([^']*)'|(\S+))`)
+
+// liquidPrefix matches the {{ site.baseurl }} (or {{ .Site.BaseURL }}) in front
+// of image paths
+var liquidPrefix = regexp.MustCompile(`^\{\{[^}]*\}\}`)
+
+// generatedDirs are the build output and dependencies of Hugo and Jekyll sites
+var generatedDirs = []string{"public", "resources", "_site", "node_modules", "vendor"}
+
+// WriteAltData finds the images without alt text that the Markdown and HTML
+// content of the Hugo or Jekyll site PATH references, captions them and writes
+// the alt texts to the --alt-data file, keyed by the path of the image on the
+// site. Images that are in the data file already are skipped.
+func WriteAltData(ol *hostPool, args args) error {
+	alts, err := readAltData(args.AltData)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", args.AltData, err)
+	}
+	images, err := siteImagesWithoutAlt(args.Path)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(images))
+	for key := range images {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	prompt := altPrompt(args)
+	var b backlog
+	for _, key := range keys {
+		b.Images++
+		if _, ok := alts[key]; ok && !args.Force {
+			b.Existing++
+			continue
+		}
+		imgData, err := readImage(images[key])
+		if err == nil {
+			imgData, err = Preprocess(imgData, args.steps)
+		}
+		if err != nil {
+			logInfo("Skipping image %s: %v", images[key], err)
+			continue
+		}
+		alt, err := suggestAlt(ol, args, prompt, imgData)
+		if err != nil {
+			return err
+		}
+		printResult(args, result{Path: key, Caption: alt}, "")
+		alts[key] = alt
+		// written after every image so an interrupted run keeps its alt texts
+		if !args.DryRun {
+			err = writeAltData(args.AltData, alts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	stats.skipped(b)
+	logVerbose("Found %d images without alt text in %s, %d in %s already", b.Images, args.Path, b.Existing, args.AltData)
+	return nil
+}
+
+// siteImagesWithoutAlt returns the files of the images that are referenced
+// without alt text by their key. Absolute references (/images/a.jpg) are the
+// key as written and live in static/ (Hugo) or the site root (Jekyll),
+// relative ones (page bundles) are keyed by the path below content/ like
+// posts/trip/beach.jpg, which a render hook gets with
+// path.Join .Page.File.Dir .Destination.
+func siteImagesWithoutAlt(site string) (map[string]string, error) {
+	content := filepath.Join(site, "content")
+	if info, err := os.Stat(content); err != nil || !info.IsDir() {
+		content = site
+	}
+	images := map[string]string{}
+	err := filepath.Walk(site, func(currentPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Continue walking despite errors
+		}
+		if info.IsDir() {
+			if currentPath != site && (strings.HasPrefix(info.Name(), ".") || contains(generatedDirs, info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(currentPath))
+		if ext != ".md" && ext != ".markdown" && ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		body, err := os.ReadFile(currentPath)
+		if err != nil {
+			logInfo("Skipping page %s: %v", currentPath, err)
+			return nil
+		}
+		for _, src := range contentImagesWithoutAlt(body) {
+			key, file, ok := siteImageFile(site, content, currentPath, src)
+			if !ok {
+				logVerbose("Skipping image %q on %s, the file was not found", src, currentPath)
+				continue
+			}
+			images[key] = file
+		}
+		return nil
+	})
+	return images, err
+}
+
+// contentImagesWithoutAlt returns the src of the Markdown images, figure
+// shortcodes and img tags without alt text
+func contentImagesWithoutAlt(body []byte) []string {
+	var srcs []string
+	for _, m := range markdownImage.FindAllSubmatch(body, -1) {
+		if len(bytes.TrimSpace(m[1])) == 0 {
+			srcs = append(srcs, string(m[2]))
+		}
+	}
+	for _, m := range figureShortcode.FindAllSubmatch(body, -1) {
+		params := map[string]string{}
+		for _, p := range shortcodeParam.FindAllStringSubmatch(string(m[1]), -1) {
+			params[p[1]] = strings.TrimSpace(p[2] + p[3] + p[4])
+		}
+		if params["src"] != "" && params["alt"] == "" {
+			srcs = append(srcs, params["src"])
+		}
+	}
+	// Markdown may contain HTML, a broken tag just ends the scan
+	refs, _ := imagesWithoutAlt(body)
+	for _, ref := range refs {
+		srcs = append(srcs, ref.src)
+	}
+	return srcs
+}
+
+// siteImageFile finds the file of the image that the page references and
+// returns its key in the data file
+func siteImageFile(site string, content string, page string, src string) (string, string, bool) {
+	src = strings.TrimSpace(liquidPrefix.ReplaceAllString(src, ""))
+	src, _, _ = strings.Cut(src, "#")
+	src, _, _ = strings.Cut(src, "?")
+	if src == "" || isURL(src) || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "data:") || strings.Contains(src, "{{") {
+		return "", "", false
+	}
+	if strings.HasPrefix(src, "/") {
+		key := path.Clean(src)
+		for _, dir := range []string{filepath.Join(site, "static"), filepath.Join(site, "assets"), site} {
+			file := filepath.Join(dir, filepath.FromSlash(key))
+			if isImageFile(file) && fileExists(file) {
+				return key, file, true
+			}
+		}
+		return "", "", false
+	}
+	file := filepath.Join(filepath.Dir(page), filepath.FromSlash(src))
+	rel, err := filepath.Rel(content, file)
+	if err != nil || strings.HasPrefix(rel, "..") || !isImageFile(file) || !fileExists(file) {
+		return "", "", false
+	}
+	return filepath.ToSlash(rel), file, true
+}
+
+// readAltData reads the flat map of a YAML, JSON or TOML data file, a missing
+// file is empty. YAML is read line by line as key: value.
+func readAltData(file string) (map[string]string, error) {
+	alts := map[string]string{}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return alts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		err = json.Unmarshal(data, &alts)
+	case ".toml":
+		err = toml.Unmarshal(data, &alts)
+	default:
+		for n, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+				continue
+			}
+			key, value, ok := cutYAMLScalar(line)
+			value = strings.TrimSpace(strings.TrimPrefix(value, ":"))
+			if ok && (strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'")) {
+				value, _, ok = cutYAMLScalar(value)
+			}
+			if !ok {
+				return nil, fmt.Errorf("line %d is no key: value", n+1)
+			}
+			alts[key] = value
+		}
+	}
+	return alts, err
+}
+
+// cutYAMLScalar reads a double quoted, single quoted or plain key from the
+// start of the line and returns the rest
+func cutYAMLScalar(line string) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(line, `"`):
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				var s string
+				err := json.Unmarshal([]byte(line[:i+1]), &s)
+				return s, line[i+1:], err == nil
+			}
+		}
+		return "", "", false
+	case strings.HasPrefix(line, "'"):
+		end := strings.Index(line[1:], "'")
+		if end < 0 {
+			return "", "", false
+		}
+		return line[1 : end+1], line[end+2:], true
+	default:
+		key, rest, ok := strings.Cut(line, ": ")
+		if !ok {
+			return strings.TrimSpace(strings.TrimSuffix(line, ":")), ":", true
+		}
+		return strings.TrimSpace(key), ":" + rest, true
+	}
+}
+
+// writeAltData writes the alt texts sorted by key, the JSON quoting of the
+// strings is valid in YAML and TOML too
+func writeAltData(file string, alts map[string]string) error {
+	keys := make([]string, 0, len(alts))
+	for key := range alts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	quote := func(s string) string {
+		q, _ := json.Marshal(s)
+		return string(q)
+	}
+	var b strings.Builder
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		b.WriteString("{\n")
+		for i, key := range keys {
+			fmt.Fprintf(&b, "  %s: %s", quote(key), quote(alts[key]))
+			if i < len(keys)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("}\n")
+	case ".toml":
+		fmt.Fprintf(&b, "# alt texts of the images, written by %s\n", appName)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s = %s\n", quote(key), quote(alts[key]))
+		}
+	default:
+		fmt.Fprintf(&b, "# alt texts of the images, written by %s\n", appName)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s: %s\n", quote(key), quote(alts[key]))
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err == nil {
+		err = os.WriteFile(file, []byte(b.String()), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
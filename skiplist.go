@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the keys other tools use for the image in their manifests
+// (kohya metadata, Hugging Face metadata.jsonl, tagger CSVs)
+var skipListKeys = []string{"file_name", "filename", "file", "image", "image_path", "image_key", "path"}
+
+// skipList holds the images that already got captioned by other tools
+type skipList struct {
+	paths map[string]bool
+	// entries without extension (like kohya image keys) match every image with that name
+	stems map[string]bool
+}
+
+// loadSkipLists reads the registries of --skip-from. Relative paths in a
+// registry are relative to the directory of the registry file. Supported are
+// JSON (an object keyed by image or an array), JSON lines, CSV with a header
+// and plain lists with one image per line (extra tab separated columns are ignored).
+func loadSkipLists(files []string) (*skipList, error) {
+	s := &skipList{paths: map[string]bool{}, stems: map[string]bool{}}
+	for _, file := range files {
+		entries, err := readSkipList(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read skip list %s: %w", file, err)
+		}
+		dir := filepath.Dir(file)
+		for _, entry := range entries {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			path := filepath.FromSlash(entry)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			path, err = filepath.Abs(path)
+			if err != nil {
+				return nil, err
+			}
+			if filepath.Ext(path) == "" {
+				s.stems[path] = true
+			} else {
+				s.paths[path] = true
+			}
+		}
+		logVerbose("Loaded %d entries from skip list %s", len(entries), file)
+	}
+	return s, nil
+}
+
+// contains checks if the image is listed in one of the skip lists
+func (s *skipList) contains(path string) bool {
+	if s == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return s.paths[abs] || s.stems[strings.TrimSuffix(abs, filepath.Ext(abs))]
+}
+
+func readSkipList(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return jsonSkipEntries(data)
+	case ".jsonl", ".ndjson":
+		var entries []string
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			lineEntries, err := jsonSkipEntries(line)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, lineEntries...)
+		}
+		return entries, scanner.Err()
+	case ".csv":
+		return csvSkipEntries(data)
+	}
+
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var entries []string
+	for _, line := range strings.Split(string(data), sep) {
+		path, _, _ := strings.Cut(strings.TrimSuffix(line, "\r"), "\t")
+		entries = append(entries, path)
+	}
+	return entries, nil
+}
+
+// jsonSkipEntries accepts an object keyed by the images, a single record
+// (a line of JSON lines) or an array of paths or records
+func jsonSkipEntries(data []byte) ([]string, error) {
+	var value any
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		if path, ok := skipRecordPath(v); ok {
+			return []string{path}, nil
+		}
+		var entries []string
+		for key := range v {
+			entries = append(entries, key)
+		}
+		return entries, nil
+	case []any:
+		var entries []string
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				entries = append(entries, item)
+			case map[string]any:
+				if path, ok := skipRecordPath(item); ok {
+					entries = append(entries, path)
+				}
+			}
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("unsupported JSON layout")
+}
+
+func skipRecordPath(record map[string]any) (string, bool) {
+	for _, key := range skipListKeys {
+		if path, ok := record[key].(string); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// csvSkipEntries uses the image column of the header or the first column
+func csvSkipEntries(data []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	column := -1
+	for i, name := range records[0] {
+		if contains(skipListKeys, strings.ToLower(strings.TrimSpace(name))) {
+			column = i
+			break
+		}
+	}
+	if column < 0 {
+		// no header, so the first column holds the images
+		column = 0
+	} else {
+		records = records[1:]
+	}
+	var entries []string
+	for _, record := range records {
+		if column < len(record) {
+			entries = append(entries, record[column])
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// imageState is what we remember about a failing image across runs
+type imageState struct {
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	Poisoned  bool      `json:"poisoned,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// runState is persisted in the --state file. Images that get captioned
+// successfully are removed from it again.
+type runState struct {
+	mu     sync.Mutex
+	file   string
+	Images map[string]*imageState `json:"images"`
+}
+
+func loadState(file string) (*runState, error) {
+	state := &runState{file: file, Images: map[string]*imageState{}}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Images == nil {
+		state.Images = map[string]*imageState{}
+	}
+	return state, nil
+}
+
+// save writes the state (with the lock held) to a temporary file first, so it never gets lost half written
+func (s *runState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// key makes the state independent of the working directory
+func (s *runState) key(imagePath string) string {
+	abs, err := filepath.Abs(imagePath)
+	if err != nil {
+		return imagePath
+	}
+	return abs
+}
+
+func (s *runState) isPoisoned(imagePath string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, ok := s.Images[s.key(imagePath)]
+	return ok && img.Poisoned
+}
+
+// failed records a failed attempt and reports if the image is poisoned now
+func (s *runState) failed(imagePath string, cause error, maxAttempts int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(imagePath)
+	img, ok := s.Images[key]
+	if !ok {
+		img = &imageState{}
+		s.Images[key] = img
+	}
+	img.Attempts++
+	img.LastError = cause.Error()
+	img.Updated = time.Now()
+	img.Poisoned = img.Attempts >= maxAttempts
+	return img.Poisoned, s.save()
+}
+
+func (s *runState) succeeded(imagePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(imagePath)
+	if _, ok := s.Images[key]; !ok {
+		return nil
+	}
+	delete(s.Images, key)
+	return s.save()
+}
+
+// poisonedImages lists all poisoned images sorted by path
+func (s *runState) poisonedImages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for path, img := range s.Images {
+		if img.Poisoned {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how many of the slowest images are listed in the summary
+const slowestCount = 5
+
+type imageDuration struct {
+	Path    string  `json:"path"`
+	Seconds float64 `json:"seconds"`
+}
+
+// runStats collects what happened during a run for the summary at the end
+type runStats struct {
+	mu               sync.Mutex
+	start            time.Time
+	Processed        int               `json:"processed"`
+	SkippedExisting  int               `json:"skipped_existing"`
+	SkippedImported  int               `json:"skipped_imported"`
+	SkippedPoisoned  int               `json:"skipped_poisoned"`
+	Filtered         int               `json:"filtered"` // dropped by the date, shard and ignore filters
+	Failed           int               `json:"failed"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+	WallSeconds      float64           `json:"wall_seconds"`
+	AverageSeconds   float64           `json:"average_seconds_per_image"`
+	EstimatedCost    *float64          `json:"estimated_cost,omitempty"`
+	Slowest          []imageDuration   `json:"slowest"`
+	OverClipBudget   []string          `json:"over_clip_budget,omitempty"`
+	Fallback         []string          `json:"fallback,omitempty"`
+	Corrupt          []string          `json:"corrupt,omitempty"`
+	Ratings          map[string]string `json:"ratings,omitempty"`    // the --rating of every image
+	Duplicates       map[string]string `json:"duplicates,omitempty"` // the near-duplicates of --dedupe and their originals
+	Bursts           map[string]string `json:"bursts,omitempty"`     // the frames of --bursts and the frame whose caption they got
+	Diffed           int               `json:"diffed,omitempty"`     // the captions compared by --diff
+	Changed          []string          `json:"changed,omitempty"`    // and the images whose caption changed
+	durations        []imageDuration
+	models           map[string]*tokenUsage
+}
+
+var stats = &runStats{start: time.Now(), models: map[string]*tokenUsage{}}
+
+func (s *runStats) addTokens(model string, usage tokenUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PromptTokens += usage.Prompt
+	s.CompletionTokens += usage.Completion
+	if s.models[model] == nil {
+		s.models[model] = &tokenUsage{}
+	}
+	s.models[model].add(usage)
+}
+
+// diffed counts the captions compared by --diff
+func (s *runStats) diffed(path string, changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Diffed++
+	if changed {
+		s.Changed = append(s.Changed, path)
+	}
+}
+
+func (s *runStats) imageDone(path string, duration time.Duration, err error) {
+	metrics.dequeue()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.Failed++
+		return
+	}
+	s.Processed++
+	s.durations = append(s.durations, imageDuration{Path: path, Seconds: duration.Seconds()})
+}
+
+// failures is the number of images that failed so far
+func (s *runStats) failures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Failed
+}
+
+// overBudget records an image whose caption is longer than --clip-budget
+func (s *runStats) overBudget(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.OverClipBudget = append(s.OverClipBudget, path)
+}
+
+// fellBack records an image that was captioned with --fallback-model
+func (s *runStats) fellBack(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Fallback = append(s.Fallback, path)
+}
+
+// corrupted records an image that could not be decoded
+func (s *runStats) corrupted(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Corrupt = append(s.Corrupt, path)
+}
+
+// rated records the --rating of an image
+func (s *runStats) rated(path string, rating string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Ratings == nil {
+		s.Ratings = map[string]string{}
+	}
+	s.Ratings[path] = rating
+}
+
+// duplicated records the near-duplicates of a scan
+func (s *runStats) duplicated(duplicates map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Duplicates == nil {
+		s.Duplicates = map[string]string{}
+	}
+	for path, original := range duplicates {
+		s.Duplicates[path] = original
+	}
+}
+
+// bursted records the frames of the bursts of a scan
+func (s *runStats) bursted(frames map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Bursts == nil {
+		s.Bursts = map[string]string{}
+	}
+	for path, middle := range frames {
+		s.Bursts[path] = middle
+	}
+}
+
+// skipped sets the skipped images of the last scan
+func (s *runStats) skipped(b backlog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SkippedExisting = b.Existing
+	s.SkippedImported = b.Imported
+	s.SkippedPoisoned = b.Poisoned
+	s.Filtered = b.Filtered
+}
+
+// update calculates the derived values
+func (s *runStats) update() {
+	s.WallSeconds = time.Since(s.start).Seconds()
+	total := 0.0
+	for _, d := range s.durations {
+		total += d.Seconds
+	}
+	s.AverageSeconds = 0
+	if len(s.durations) > 0 {
+		s.AverageSeconds = total / float64(len(s.durations))
+	}
+	slowest := make([]imageDuration, len(s.durations))
+	copy(slowest, s.durations)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Seconds > slowest[j].Seconds
+	})
+	s.Slowest = slowest[:min(len(slowest), slowestCount)]
+
+	// the costs are only known if every used model has a price
+	s.EstimatedCost = nil
+	if prices != nil {
+		total := 0.0
+		for model, usage := range s.models {
+			cost, ok := prices.cost(model, *usage)
+			if !ok {
+				return
+			}
+			total += cost
+		}
+		s.EstimatedCost = &total
+	}
+}
+
+// summary returns the lines that are logged at the end of a run
+func (s *runStats) summary() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update()
+	lines := []string{
+		fmt.Sprintf("Summary: %d processed, %d skipped (existing caption), %d skipped (imported), %d skipped (poisoned), %d filtered, %d failed in %s (%.1fs per image)",
+			s.Processed, s.SkippedExisting, s.SkippedImported, s.SkippedPoisoned, s.Filtered, s.Failed,
+			time.Duration(s.WallSeconds*float64(time.Second)).Round(time.Second), s.AverageSeconds),
+	}
+	if s.EstimatedCost != nil {
+		lines = append(lines, fmt.Sprintf("Tokens: %d prompt, %d completion (estimated cost %s)", s.PromptTokens, s.CompletionTokens, formatCost(*s.EstimatedCost)))
+	} else {
+		lines = append(lines, fmt.Sprintf("Tokens: %d prompt, %d completion", s.PromptTokens, s.CompletionTokens))
+	}
+	if len(s.Slowest) > 0 {
+		var slow []string
+		for _, d := range s.Slowest {
+			slow = append(slow, fmt.Sprintf("%s (%.1fs)", d.Path, d.Seconds))
+		}
+		lines = append(lines, "Slowest: "+strings.Join(slow, ", "))
+	}
+	if len(s.OverClipBudget) > 0 {
+		lines = append(lines, fmt.Sprintf("Over the CLIP budget (%d): %s", len(s.OverClipBudget), strings.Join(s.OverClipBudget, ", ")))
+	}
+	if len(s.Ratings) > 0 {
+		count := map[string]int{}
+		for _, rating := range s.Ratings {
+			count[rating]++
+		}
+		var parts []string
+		for _, rating := range safetyRatings {
+			parts = append(parts, fmt.Sprintf("%d %s", count[rating], rating))
+		}
+		lines = append(lines, "Ratings: "+strings.Join(parts, ", "))
+	}
+	if len(s.Corrupt) > 0 {
+		lines = append(lines, fmt.Sprintf("Corrupt (%d): %s", len(s.Corrupt), strings.Join(s.Corrupt, ", ")))
+	}
+	if len(s.Duplicates) > 0 {
+		lines = append(lines, fmt.Sprintf("Near-duplicates: %d", len(s.Duplicates)))
+	}
+	if len(s.Bursts) > 0 {
+		lines = append(lines, fmt.Sprintf("Burst frames: %d", len(s.Bursts)))
+	}
+	if s.Diffed > 0 {
+		lines = append(lines, fmt.Sprintf("Changed captions: %d of %d", len(s.Changed), s.Diffed))
+	}
+	if len(s.Fallback) > 0 {
+		lines = append(lines, fmt.Sprintf("Captioned with the fallback model (%d): %s", len(s.Fallback), strings.Join(s.Fallback, ", ")))
+	}
+	return lines
+}
+
+// runManifest is written by --summary. Besides the statistics it records
+// the configuration of the run, so it can be executed again with "rerun".
+type runManifest struct {
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	Config  args      `json:"config"`
+	*runStats
+}
+
+// redactConfig removes the passwords of the URLs from the configuration of
+// the manifest. The user names are kept, so a rerun reads the passwords from
+// MQTT_PASSWORD and the other environment variables.
+func redactConfig(config args) args {
+	config.Hosts = append([]string(nil), config.Hosts...)
+	for _, field := range []*string{&config.FallbackHost, &config.AzureEndpoint, &config.LlamaCpp,
+		&config.OpenAIURL, &config.WordPress, &config.Webhook, &config.MQTT} {
+		*field = redactURL(*field)
+	}
+	for i, host := range config.Hosts {
+		config.Hosts[i] = redactURL(host)
+	}
+	return config
+}
+
+// redactURL drops the password of a URL
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+func (s *runStats) writeJSON(file string, config args) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update()
+	manifest := runManifest{
+		Version:  strings.TrimSpace(fullVersion),
+		Started:  s.start,
+		Config:   redactConfig(config),
+		runStats: s,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var captionModes = []string{"caption", "tags", "dual", "ocr"}
+
+const tagsPrompt = "List the tags that describe this image for an image board, like \"1girl, red dress, outdoors, smiling\". Start with the number and kind of subjects, then their appearance, clothing, pose and expression, then the background, the lighting and the style. Answer only with the tags separated by commas."
+
+func isValidMode(mode string) bool {
+	return contains(captionModes, mode)
+}
+
+// list markers and labels models like to add although they were told not to
+var (
+	tagMarkerRE = regexp.MustCompile(`^(?:[-*•]+|\d+[.)])\s*`)
+	tagLabelRE  = regexp.MustCompile(`(?i)^tags\s*:\s*`)
+)
+
+// normalizeTags splits the answer into tags, cleans them up and removes
+// duplicates while keeping the order of the model
+func normalizeTags(args args, answer string) []string {
+	answer = tagLabelRE.ReplaceAllString(strings.TrimSpace(answer), "")
+	fields := strings.FieldsFunc(answer, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	})
+	var tags []string
+	seen := map[string]bool{}
+	for _, tag := range fields {
+		tag = tagMarkerRE.ReplaceAllString(strings.TrimSpace(tag), "")
+		tag = strings.Trim(tag, " \t\"'`.")
+		tag = strings.Join(strings.Fields(tag), " ")
+		if args.Lowercase {
+			tag = strings.ToLower(tag)
+		}
+		if args.Underscores {
+			tag = strings.ReplaceAll(tag, " ", "_")
+		}
+		key := strings.ToLower(strings.ReplaceAll(tag, "_", " "))
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// finishTags adds the trigger word and --start as tags in front and --end at the end
+func finishTags(args args, answer string) string {
+	var tags []string
+	if args.Trigger != "" {
+		tags = append(tags, strings.Trim(args.Trigger, " ,"))
+	}
+	if args.StartCaption != "" {
+		tags = append(tags, strings.Trim(args.StartCaption, " ,"))
+	}
+	tags = append(tags, normalizeTags(args, answer)...)
+	if args.EndCaption != "" {
+		tags = append(tags, strings.Trim(args.EndCaption, " ,"))
+	}
+	return strings.Join(tags, ", ")
+}
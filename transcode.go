@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// decoded images are copied a few times while transcoding (source, working
+// copy and result), so we reserve that much memory per pixel
+const transcodeBytesPerPixel = 4 * 3
+
+// transcoder runs the decoding, resizing and encoding of images with a bounded
+// number of workers and a budget for the memory of the decoded pixels. The
+// encoding buffers are reused between jobs.
+type transcoder struct {
+	slots  chan struct{}
+	memory *byteBudget
+	bufs   sync.Pool
+}
+
+var transcoding = newTranscoder(0, 1024)
+
+// newTranscoder creates a transcoder with the number of workers (0 for the
+// number of CPUs) and the memory budget in MB
+func newTranscoder(workers int, memoryMB int64) *transcoder {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	t := &transcoder{
+		slots:  make(chan struct{}, workers),
+		memory: newByteBudget(max(memoryMB, 1) << 20),
+	}
+	t.bufs.New = func() any {
+		return new(bytes.Buffer)
+	}
+	return t
+}
+
+// run executes the work for the image once a worker and enough memory are
+// available and returns a copy of what the work wrote into the buffer
+func (t *transcoder) run(imgData []byte, work func(buf *bytes.Buffer) error) ([]byte, error) {
+	need := t.memory.acquire(t.estimate(imgData))
+	defer t.memory.release(need)
+
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	buf := t.bufs.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer t.bufs.Put(buf)
+
+	err := work(buf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// estimate reads the image header to calculate the memory of the decoded pixels
+func (t *transcoder) estimate(imgData []byte) int64 {
+	need := int64(len(imgData))
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData))
+	if err == nil {
+		need += int64(cfg.Width) * int64(cfg.Height) * transcodeBytesPerPixel
+	}
+	return need
+}
+
+// byteBudget limits how many bytes are in use at the same time
+type byteBudget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	budget int64
+	used   int64
+}
+
+// newByteBudget creates a budget, 0 means unlimited
+func newByteBudget(budget int64) *byteBudget {
+	b := &byteBudget{budget: budget}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until the bytes fit into the budget and returns the amount
+// that has to be released. Requests bigger than the whole budget still run, but alone.
+func (b *byteBudget) acquire(need int64) int64 {
+	if b.budget <= 0 {
+		return 0
+	}
+	need = min(need, b.budget)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used+need > b.budget {
+		b.cond.Wait()
+	}
+	b.used += need
+	return need
+}
+
+func (b *byteBudget) release(amount int64) {
+	if b.budget <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= amount
+	b.cond.Broadcast()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var unitSystems = []string{"metric", "imperial"}
+
+var numeralStyles = []string{"digits", "words"}
+
+func isValidUnits(units string) bool {
+	return units == "" || contains(unitSystems, units)
+}
+
+func isValidNumerals(numerals string) bool {
+	return numerals == "" || contains(numeralStyles, numerals)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// localeHint is added to the prompt to ask for the units and numerals of --units and --numerals
+func localeHint(args args) string {
+	var hint string
+	switch args.Units {
+	case "metric":
+		hint += "\nState all measurements, distances and weights in metric units (cm, m, km, kg, °C)."
+	case "imperial":
+		hint += "\nState all measurements, distances and weights in imperial units (inches, feet, miles, pounds, °F)."
+	}
+	switch args.Numerals {
+	case "digits":
+		hint += "\nWrite all numbers and ages as digits (2 people, 30 years old)."
+	case "words":
+		hint += "\nWrite numbers below one hundred and ages in words (two people, thirty years old), but use digits for measurements."
+	}
+	return hint + languageHint(args)
+}
+
+// unitConversion converts a measurement from one unit into the other unit system
+type unitConversion struct {
+	to     string
+	factor float64
+	offset float64
+}
+
+var toMetric = map[string]unitConversion{
+	"inch":   {"cm", 2.54, 0},
+	"foot":   {"m", 0.3048, 0},
+	"yard":   {"m", 0.9144, 0},
+	"mile":   {"km", 1.609344, 0},
+	"ounce":  {"g", 28.349523, 0},
+	"pound":  {"kg", 0.45359237, 0},
+	"fahren": {"°C", 5.0 / 9, -32 * 5.0 / 9},
+}
+
+var toImperial = map[string]unitConversion{
+	"mm":      {"inches", 1 / 25.4, 0},
+	"cm":      {"inches", 1 / 2.54, 0},
+	"m":       {"feet", 1 / 0.3048, 0},
+	"km":      {"miles", 1 / 1.609344, 0},
+	"g":       {"ounces", 1 / 28.349523, 0},
+	"kg":      {"pounds", 1 / 0.45359237, 0},
+	"celsius": {"°F", 9.0 / 5, 32},
+}
+
+// the unit names as the model writes them, mapped to the keys of the conversions
+var unitNames = map[string]string{
+	"inch": "inch", "inches": "inch",
+	"foot": "foot", "feet": "foot", "ft": "foot",
+	"yard": "yard", "yards": "yard", "yd": "yard",
+	"mile": "mile", "miles": "mile", "mi": "mile",
+	"ounce": "ounce", "ounces": "ounce", "oz": "ounce",
+	"pound": "pound", "pounds": "pound", "lb": "pound", "lbs": "pound",
+	"°f": "fahren", "degrees fahrenheit": "fahren", "fahrenheit": "fahren",
+	"mm": "mm", "millimeter": "mm", "millimeters": "mm", "millimetre": "mm", "millimetres": "mm",
+	"cm": "cm", "centimeter": "cm", "centimeters": "cm", "centimetre": "cm", "centimetres": "cm",
+	"m": "m", "meter": "m", "meters": "m", "metre": "m", "metres": "m",
+	"km": "km", "kilometer": "km", "kilometers": "km", "kilometre": "km", "kilometres": "km",
+	"g": "g", "gram": "g", "grams": "g",
+	"kg": "kg", "kilogram": "kg", "kilograms": "kg",
+	"°c": "celsius", "degrees celsius": "celsius", "celsius": "celsius",
+}
+
+var measurementRE = regexp.MustCompile(`(?i)\b(\d+(?:\.\d+)?)(?:\s*|-)(degrees fahrenheit|degrees celsius|fahrenheit|celsius|°[fc]|millimet(?:er|re)s?|centimet(?:er|re)s?|kilomet(?:er|re)s?|met(?:er|re)s?|kilograms?|grams?|inch(?:es)?|f(?:oo|ee)t|yards?|miles?|ounces?|pounds?|lbs?|mm|cm|km|kg|ft|yd|mi|oz|m|g)\b`)
+
+var numberWords = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen", "twenty"}
+
+var tensWords = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+
+var numberWordRE = regexp.MustCompile(`(?i)\b(twenty|thirty|forty|fifty|sixty|seventy|eighty|ninety)(?:[- ](one|two|three|four|five|six|seven|eight|nine))?\b|\b(zero|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|thirteen|fourteen|fifteen|sixteen|seventeen|eighteen|nineteen)\b`)
+
+var integerRE = regexp.MustCompile(`\b\d+(?:[.,]\d+)?\b`)
+
+// localize is the post-check for --units and --numerals. It converts the
+// measurements the model still wrote in the other unit system and fixes the
+// style of the numbers.
+func localize(args args, caption string) string {
+	if args.Units != "" {
+		conversions := toMetric
+		if args.Units == "imperial" {
+			conversions = toImperial
+		}
+		caption = measurementRE.ReplaceAllStringFunc(caption, func(m string) string {
+			parts := measurementRE.FindStringSubmatch(m)
+			conv, ok := conversions[unitNames[strings.ToLower(parts[2])]]
+			if !ok {
+				return m
+			}
+			value, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return m
+			}
+			converted := formatMeasurement(value*conv.factor + conv.offset)
+			if strings.HasPrefix(conv.to, "°") {
+				return converted + conv.to
+			}
+			return converted + " " + conv.to
+		})
+	}
+
+	switch args.Numerals {
+	case "digits":
+		caption = numberWordRE.ReplaceAllStringFunc(caption, func(m string) string {
+			parts := numberWordRE.FindStringSubmatch(m)
+			if parts[3] != "" {
+				// "one" is too often not a number ("the one on the left")
+				if strings.EqualFold(parts[3], "one") {
+					return m
+				}
+				return strconv.Itoa(wordIndex(numberWords, parts[3]))
+			}
+			n := wordIndex(tensWords, parts[1]) * 10
+			if parts[2] != "" {
+				n += wordIndex(numberWords, parts[2])
+			}
+			return strconv.Itoa(n)
+		})
+	case "words":
+		caption = replaceUnmeasured(caption, numberToWords)
+	}
+	return caption
+}
+
+// replaceUnmeasured replaces the integers that are not part of a measurement
+func replaceUnmeasured(caption string, replace func(int) (string, bool)) string {
+	measured := measurementRE.FindAllStringIndex(caption, -1)
+	var b strings.Builder
+	last := 0
+	for _, loc := range integerRE.FindAllStringIndex(caption, -1) {
+		if isMeasured(loc[0], measured) {
+			continue
+		}
+		n, err := strconv.Atoi(caption[loc[0]:loc[1]])
+		if err != nil {
+			continue
+		}
+		word, ok := replace(n)
+		if !ok {
+			continue
+		}
+		b.WriteString(caption[last:loc[0]])
+		b.WriteString(word)
+		last = loc[1]
+	}
+	b.WriteString(caption[last:])
+	return b.String()
+}
+
+func isMeasured(pos int, measured [][]int) bool {
+	for _, loc := range measured {
+		if pos >= loc[0] && pos < loc[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// numberToWords spells the numbers below 100
+func numberToWords(n int) (string, bool) {
+	switch {
+	case n < 0 || n >= 100:
+		return "", false
+	case n <= 20:
+		return numberWords[n], true
+	case n%10 == 0:
+		return tensWords[n/10], true
+	}
+	return tensWords[n/10] + "-" + numberWords[n%10], true
+}
+
+func wordIndex(words []string, word string) int {
+	for i, w := range words {
+		if strings.EqualFold(w, word) {
+			return i
+		}
+	}
+	return 0
+}
+
+// formatMeasurement rounds to whole numbers for big values and keeps one decimal for small ones
+func formatMeasurement(v float64) string {
+	if math.Abs(v) >= 10 {
+		return strconv.FormatFloat(math.Round(v), 'f', -1, 64)
+	}
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
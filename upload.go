@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ollama/ollama/api"
+)
+
+// the longest line we accept in a streamed response
+const maxResponseLine = 512 * 1024
+
+// payloads limits the bytes of the encoded images of all requests in flight (--max-payload-inflight)
+var payloads = newByteBudget(0)
+
+// ollamaAPI is the part of the Ollama API that is used for captioning
+type ollamaAPI interface {
+	Generate(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error
+	Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error
+}
+
+// streamingClient talks to the same Ollama API as api.Client, but streams the
+// request body. The images are base64 encoded while they are sent, so the
+// encoded copy of the images (and of the JSON request) is never held in memory.
+type streamingClient struct {
+	base *url.URL
+	http *http.Client
+}
+
+func newStreamingClient(base *url.URL) *streamingClient {
+	return &streamingClient{base: base, http: backendClient}
+}
+
+// encodedSize is the size of the images in the request body
+func encodedSize(images [][]byte) int64 {
+	size := int64(0)
+	for _, img := range images {
+		size += int64(base64.StdEncoding.EncodedLen(len(img)))
+	}
+	return size
+}
+
+func (c *streamingClient) Generate(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+	images := req.Images
+	stripped := *req
+	stripped.Images = nil
+	head, err := json.Marshal(stripped)
+	if err != nil {
+		return err
+	}
+	// the images field is omitted, so we add it before the closing brace
+	head = bytes.TrimSuffix(head, []byte("}"))
+	body := func(w io.Writer) error {
+		err := writeAll(w, head, []byte(`,"images":`))
+		if err != nil {
+			return err
+		}
+		err = writeImages(w, images)
+		if err != nil {
+			return err
+		}
+		return writeAll(w, []byte("}"))
+	}
+	return c.stream(ctx, "/api/generate", body, func(line []byte) error {
+		var resp api.GenerateResponse
+		err := json.Unmarshal(line, &resp)
+		if err != nil {
+			return err
+		}
+		return fn(resp)
+	})
+}
+
+func (c *streamingClient) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	messages := req.Messages
+	stripped := *req
+	stripped.Messages = nil
+	head, err := json.Marshal(stripped)
+	if err != nil {
+		return err
+	}
+	// split at the messages placeholder, the strings in the request can't
+	// contain it because their quotes are escaped
+	before, after, ok := bytes.Cut(head, []byte(`"messages":null`))
+	if !ok {
+		return fmt.Errorf("unexpected chat request encoding")
+	}
+	body := func(w io.Writer) error {
+		err := writeAll(w, before, []byte(`"messages":[`))
+		if err != nil {
+			return err
+		}
+		for i, msg := range messages {
+			images := msg.Images
+			msg.Images = nil
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				err = writeAll(w, []byte(","))
+				if err != nil {
+					return err
+				}
+			}
+			if len(images) == 0 {
+				err = writeAll(w, data)
+			} else {
+				err = writeAll(w, bytes.TrimSuffix(data, []byte("}")), []byte(`,"images":`))
+				if err == nil {
+					err = writeImages(w, images)
+				}
+				if err == nil {
+					err = writeAll(w, []byte("}"))
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return writeAll(w, []byte("]"), after)
+	}
+	return c.stream(ctx, "/api/chat", body, func(line []byte) error {
+		var resp api.ChatResponse
+		err := json.Unmarshal(line, &resp)
+		if err != nil {
+			return err
+		}
+		return fn(resp)
+	})
+}
+
+func writeAll(w io.Writer, parts ...[]byte) error {
+	for _, part := range parts {
+		_, err := w.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImages writes the images as JSON array of base64 strings
+func writeImages(w io.Writer, images []api.ImageData) error {
+	err := writeAll(w, []byte("["))
+	if err != nil {
+		return err
+	}
+	for i, img := range images {
+		if i > 0 {
+			err = writeAll(w, []byte(","))
+			if err != nil {
+				return err
+			}
+		}
+		err = writeAll(w, []byte(`"`))
+		if err != nil {
+			return err
+		}
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		_, err = enc.Write(img)
+		if err != nil {
+			return err
+		}
+		err = enc.Close()
+		if err != nil {
+			return err
+		}
+		err = writeAll(w, []byte(`"`))
+		if err != nil {
+			return err
+		}
+	}
+	return writeAll(w, []byte("]"))
+}
+
+// stream posts the body while it is written and calls fn for every line of the response
+func (c *streamingClient) stream(ctx context.Context, path string, body func(w io.Writer) error, fn func([]byte) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(body(pw))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base.JoinPath(path).String(), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxResponseLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var errorResponse struct {
+			Error string `json:"error,omitempty"`
+		}
+		err := json.Unmarshal(line, &errorResponse)
+		if err != nil {
+			return fmt.Errorf("unmarshal: %w", err)
+		}
+		if errorResponse.Error != "" {
+			return api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, ErrorMessage: errorResponse.Error}
+		}
+		if resp.StatusCode >= http.StatusBadRequest {
+			return api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status, ErrorMessage: string(line)}
+		}
+		err = fn(line)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
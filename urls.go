@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readURLs reads the list of --urls (stdin for "-"), empty lines and lines
+// starting with # are skipped and every URL is only listed once
+func readURLs(file string) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	seen := map[string]bool{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		u := strings.TrimSpace(string(line))
+		if u == "" || strings.HasPrefix(u, "#") || seen[u] {
+			continue
+		}
+		if !isURL(u) {
+			logInfo("Skipping %q, it is no http(s) URL", u)
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// captionURLs downloads the images of --urls (or the URL of PATH) into a
+// temporary cache and writes their captions to the manifest
+func captionURLs(ol *hostPool, args args) error {
+	urls := []string{args.Path}
+	if args.URLs != "" {
+		var err error
+		urls, err = readURLs(args.URLs)
+		if err != nil {
+			return err
+		}
+	}
+	m, err := openManifest(manifestFile(args), args.DryRun)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	cache, err := os.MkdirTemp("", appName)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cache)
+
+	b := backlog{Images: len(urls)}
+	var todo []string
+	for _, u := range urls {
+		if !args.Force && m.has(u) {
+			b.Existing++
+			continue
+		}
+		todo = append(todo, u)
+	}
+	stats.skipped(b)
+
+	var prog *progress
+	if args.Progress {
+		prog = startProgress(len(todo))
+	}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range work {
+				start := time.Now()
+				caption, err := captionURL(ol, args, cache, u)
+				stats.imageDone(u, time.Since(start), err)
+				prog.step()
+				if errors.Is(err, errCorruptImage) {
+					logError("Skipping %s: %v", logPath(u), err)
+					stats.corrupted(u)
+					continue
+				}
+				if err != nil {
+					logError("Failed %s: %v", logPath(u), err)
+					continue
+				}
+				printResult(args, result{Path: u, Caption: caption}, "")
+				notifyImage(args, result{Path: u, Caption: caption}, nil, time.Since(start))
+				err = m.add(manifestEntry{URL: u, Caption: caption})
+				if err != nil {
+					log.Fatalf("Could not write caption: %v", err)
+				}
+			}
+		}()
+	}
+	for _, u := range todo {
+		work <- u
+	}
+	close(work)
+	wg.Wait()
+	prog.finish()
+	return nil
+}
+
+// captionURL downloads the image into the cache and captions it, the file is
+// removed afterwards
+func captionURL(ol *hostPool, args args, cache string, u string) (string, error) {
+	data, err := fetchURL(u)
+	if err != nil {
+		return "", err
+	}
+	// the extension of the URL tells the RAW files apart
+	var ext string
+	if parsed, err := url.Parse(u); err == nil {
+		ext = strings.ToLower(path.Ext(parsed.Path))
+	}
+	sum := sha256.Sum256([]byte(u))
+	file := filepath.Join(cache, hex.EncodeToString(sum[:8])+ext)
+	err = os.WriteFile(file, data, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file)
+
+	args.people = names.match(u, "")
+	prompt, images, err := loadImage(args, file)
+	if err != nil {
+		return "", err
+	}
+	var usage tokenUsage
+	logVerbose("Captioning %s (%d bytes) with %s", logPath(u), len(images[0]), args.Model)
+	return generateCaption(ol, args, &usage, u, prompt, images...)
+}
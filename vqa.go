@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+// vqaOptions are the flags of "capollama vqa" that are not flags of caption
+type vqaOptions struct {
+	Answers        string `arg:"--answers" help:"File for the answers, the CSV with the answer column added or JSON lines if it ends with .jsonl (default QUESTIONS.answers.csv, can be QUESTIONS itself)"`
+	PathColumn     string `arg:"--path-column" help:"Column with the image paths, relative paths are relative to the CSV" default:"path"`
+	QuestionColumn string `arg:"--question-column" help:"Column with the questions" default:"question"`
+	AnswerColumn   string `arg:"--answer-column" help:"Column for the answers, rows that already have an answer are skipped without --force" default:"answer"`
+}
+
+// vqaArgs are the flags of "capollama vqa", PATH is the CSV with the questions
+type vqaArgs struct {
+	args
+	vqaOptions
+}
+
+func (vqaArgs) Description() string {
+	return "Asks the question of every row of the CSV file PATH about its image and writes the answers\n"
+}
+
+func (vqaArgs) Epilogue() string {
+	return ""
+}
+
+// parseVQA handles "capollama vqa [--answers FILE] QUESTIONS.csv"
+func parseVQA(cmdline []string) (*arg.Parser, args) {
+	va := vqaArgs{args: args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}}
+	p := newParser(appName+" vqa", &va)
+	p.MustParse(cmdline)
+	if va.FilesFrom != "" || va.Batch != "" || va.Mode != "caption" || va.Counts || len(va.ExtraPrompts) > 0 || va.PromptsFile != "" {
+		p.Fail("vqa only asks the questions of the CSV")
+	}
+	if va.Answers == "" {
+		va.Answers = strings.TrimSuffix(va.Path, filepath.Ext(va.Path)) + ".answers.csv"
+	}
+	options := va.vqaOptions
+	va.args.vqa = &options
+	return p, va.args
+}
+
+// answerQuestions asks the questions of the CSV in args.Path and writes the
+// rows with the answers to --answers
+func answerQuestions(ol *hostPool, args args) error {
+	f, err := os.Open(args.Path)
+	if err != nil {
+		return err
+	}
+	rows, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("invalid CSV %s: %w", args.Path, err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("%s has no header", args.Path)
+	}
+	header := rows[0]
+	column := func(name string) int {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i
+			}
+		}
+		return -1
+	}
+	pathCol, questionCol := column(args.vqa.PathColumn), column(args.vqa.QuestionColumn)
+	if pathCol < 0 || questionCol < 0 {
+		return fmt.Errorf("%s needs the columns %q and %q", args.Path, args.vqa.PathColumn, args.vqa.QuestionColumn)
+	}
+	answerCol := column(args.vqa.AnswerColumn)
+	if answerCol < 0 {
+		answerCol = len(header)
+		rows[0] = append(header, args.vqa.AnswerColumn)
+	}
+	dir := filepath.Dir(args.Path)
+
+	var todo []int
+	for i := 1; i < len(rows); i++ {
+		for len(rows[i]) <= answerCol {
+			rows[i] = append(rows[i], "")
+		}
+		if args.Force || rows[i][answerCol] == "" {
+			todo = append(todo, i)
+		}
+	}
+	logInfo("Asking %d of %d questions", len(todo), len(rows)-1)
+
+	var prog *progress
+	if args.Progress {
+		prog = startProgress(len(todo))
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				path := rows[i][pathCol]
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(dir, path)
+				}
+				start := time.Now()
+				answer, err := answerQuestion(ol, args, path, rows[i][questionCol])
+				stats.imageDone(path, time.Since(start), err)
+				if err != nil {
+					logError("Failed %s: %v", logPath(path), err)
+				} else {
+					// each worker only writes its own rows
+					rows[i][answerCol] = answer
+					printResult(args, result{Path: path, Caption: answer}, dir)
+				}
+				prog.step()
+			}
+		}()
+	}
+	for _, i := range todo {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	prog.finish()
+
+	if args.DryRun {
+		return nil
+	}
+	if strings.EqualFold(filepath.Ext(args.vqa.Answers), ".jsonl") {
+		return writeAnswersJSONL(args.vqa.Answers, rows)
+	}
+	out, err := os.Create(args.vqa.Answers)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	err = w.WriteAll(rows)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// answerQuestion asks the question about the image with the settings of its folder
+func answerQuestion(ol *hostPool, args args, path string, question string) (string, error) {
+	args, err := overrides.apply(args, path, "")
+	if err != nil {
+		return "", err
+	}
+	args.Prompt = question
+	prompt, images, err := loadImage(args, path)
+	if err != nil {
+		return "", err
+	}
+	var usage tokenUsage
+	logVerbose("Asking %q about %s with %s", question, path, args.Model)
+	answer, err := askInLanguage(ol, args, &usage, prompt, images...)
+	if err != nil {
+		return "", err
+	}
+	return rules.rewrite(args, path, strings.TrimSpace(localize(args, answer))), nil
+}
+
+// writeAnswersJSONL writes every row as JSON object with the columns as keys
+func writeAnswersJSONL(file string, rows [][]string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, row := range rows[1:] {
+		record := map[string]string{}
+		for i, name := range rows[0] {
+			if i < len(row) {
+				record[name] = row[i]
+			}
+		}
+		err = enc.Encode(record)
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
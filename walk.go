@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// walkOptions control which images are processed and in which order
+type walkOptions struct {
+	Order     string
+	Seed      int64
+	PDF       bool // also collect PDF files
+	NewerThan dateLimit
+	OlderThan dateLimit
+	DateFrom  string // mtime or exif
+	Follow    bool   // follow symlinked folders
+	MaxDepth  int    // 1 for the images of PATH only, 0 for no limit
+	Hidden    bool   // also walk hidden folders and files
+	GitIgnore bool   // skip what the .gitignore files exclude
+	Filtered  *int   // counts the images that the date and ignore filters drop, if set
+}
+
+// filtered counts the images that a filter dropped
+func (opts walkOptions) filtered(n int) {
+	if opts.Filtered != nil {
+		*opts.Filtered += n
+	}
+}
+
+// accepts checks if the file is collected
+func (opts walkOptions) accepts(path string) bool {
+	return isImageFile(path) || opts.PDF && isPDFFile(path)
+}
+
+var walkOrders = []string{"name", "mtime", "size", "random"}
+
+func isValidOrder(order string) bool {
+	for _, o := range walkOrders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFile is an image found while walking
+type imageFile struct {
+	Path string
+	Info os.FileInfo
+}
+
+// CollectImages walks through a given path and returns the image files in
+// the requested order together with the root directory
+func CollectImages(path string, opts walkOptions) ([]imageFile, string, error) {
+	// Get file info
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// If it's a single file, process it if it's an image
+	if !fileInfo.IsDir() {
+		if opts.accepts(path) {
+			// For single files, use the parent directory as root
+			rootDir := filepath.Dir(path)
+			return filterDates([]imageFile{{Path: path, Info: fileInfo}}, opts), rootDir, nil
+		}
+		return nil, "", nil
+	}
+
+	// For directories, walk through all files recursively
+	rootDir := path // Store the top-level directory
+	var images []imageFile
+	ignores := newIgnoreMatcher(ignoreFile)
+	if opts.GitIgnore {
+		ignores = newGitIgnoreMatcher(path)
+	}
+	walk := filepath.Walk
+	if opts.Follow {
+		walk = walkFollowingSymlinks
+	}
+	err = walk(path, func(currentPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Continue walking despite errors
+		}
+
+		// Skip hidden folders and files (starting with .), but not PATH itself
+		if !opts.Hidden && currentPath != path && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && opts.MaxDepth > 0 && currentPath != path && folderDepth(path, currentPath) >= opts.MaxDepth {
+			return filepath.SkipDir
+		}
+		if currentPath != path && ignores.ignored(path, currentPath, info.IsDir()) {
+			logDebug("Ignoring %s", currentPath)
+			if info.IsDir() {
+				// the images of the folder are not walked and not counted
+				return filepath.SkipDir
+			}
+			if opts.accepts(currentPath) {
+				opts.filtered(1)
+			}
+			return nil
+		}
+
+		if !info.IsDir() && opts.accepts(currentPath) {
+			images = append(images, imageFile{Path: currentPath, Info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	images = filterDates(images, opts)
+	sortImages(images, opts)
+	return images, rootDir, nil
+}
+
+// folderDepth is 1 for a folder in root, 2 for a folder in that folder and so on
+func folderDepth(root string, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// walkFollowingSymlinks is filepath.Walk, but it walks into symlinked folders
+// and passes the info of the files that the symlinks point to. A folder that
+// was already walked (a symlink loop) is skipped.
+func walkFollowingSymlinks(root string, fn filepath.WalkFunc) error {
+	visited := map[string]bool{}
+	var walk func(path string, info os.FileInfo) error
+	walk = func(path string, info os.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				// a broken symlink
+				return fn(path, info, err)
+			}
+			info = target
+		}
+		if !info.IsDir() {
+			return fn(path, info, nil)
+		}
+		if id, ok := dirID(path, info); ok {
+			if visited[id] {
+				logVerbose("Skipping %s, the folder was already walked", path)
+				return nil
+			}
+			visited[id] = true
+		}
+		err := fn(path, info, nil)
+		if err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fn(path, info, err)
+		}
+		for _, entry := range entries {
+			child := filepath.Join(path, entry.Name())
+			childInfo, err := os.Lstat(child)
+			if err != nil {
+				err = fn(child, nil, err)
+			} else {
+				err = walk(child, childInfo)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return walk(root, info)
+}
+
+// CollectFileList returns the image files listed in a file (or stdin for "-").
+// The list is NUL separated if it contains a NUL byte and newline separated otherwise.
+func CollectFileList(listFile string, opts walkOptions) ([]imageFile, error) {
+	var data []byte
+	var err error
+	if listFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(listFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+
+	var images []imageFile
+	ignores := newListIgnores(opts.GitIgnore)
+	for _, line := range strings.Split(string(data), sep) {
+		path := strings.TrimSuffix(line, "\r")
+		if path == "" || !opts.accepts(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			logInfo("Skipping %v", err)
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		if ignores.ignored(path) {
+			logDebug("Ignoring %s", path)
+			opts.filtered(1)
+			continue
+		}
+		images = append(images, imageFile{Path: path, Info: info})
+	}
+
+	images = filterDates(images, opts)
+	sortImages(images, opts)
+	return images, nil
+}
+
+// sortImages brings the images in the requested order. Equal keys are
+// ordered by name so the result is the same for every run.
+func sortImages(images []imageFile, opts walkOptions) {
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Path < images[j].Path
+	})
+	switch opts.Order {
+	case "mtime":
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].Info.ModTime().After(images[j].Info.ModTime())
+		})
+	case "size":
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].Info.Size() < images[j].Info.Size()
+		})
+	case "random":
+		rnd := rand.New(rand.NewSource(opts.Seed))
+		rnd.Shuffle(len(images), func(i, j int) {
+			images[i], images[j] = images[j], images[i]
+		})
+	}
+}
+
+// isImageFile checks if the file has an image or camera RAW extension
+func isImageFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || isRawFile(path)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// dirID identifies a folder by its path with the symlinks resolved, because
+// the file info on Windows has no inode
+func dirID(path string, info os.FileInfo) (string, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	return real, true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+// watchArgs are the flags of "capollama watch", all flags of caption work too
+type watchArgs struct {
+	args
+	Interval time.Duration `arg:"--interval" help:"Scan for new images again after this interval" default:"5m"`
+	Backlog  string        `arg:"--backlog" help:"Write the number of uncaptioned and done images as JSON to this file after every scan"`
+	Metrics  string        `arg:"--metrics" help:"Serve Prometheus metrics on this address (127.0.0.1:9090) at /metrics"`
+}
+
+func (watchArgs) Description() string {
+	return "Keeps running and captions the new images of a growing folder\n"
+}
+
+func (watchArgs) Epilogue() string {
+	return ""
+}
+
+// parseWatch handles "capollama watch [--interval 5m] PATH"
+func parseWatch(cmdline []string) (*arg.Parser, args) {
+	wa := watchArgs{args: args{Prompt: defaultPrompt, ReferencePrompt: referencePrompt}}
+	p := newParser(appName+" watch", &wa)
+	p.MustParse(cmdline)
+	if wa.Interval <= 0 {
+		p.Fail("--interval must be positive")
+	}
+	wa.args.watchInterval = wa.Interval
+	wa.args.backlogFile = wa.Backlog
+	wa.args.metricsAddr = wa.Metrics
+	return p, wa.args
+}
+
+// backlog is the state of the images after a scan of the watched tree
+type backlog struct {
+	Time        time.Time `json:"time"`
+	Images      int       `json:"images"`
+	Existing    int       `json:"existing"`
+	Imported    int       `json:"imported"`
+	Poisoned    int       `json:"poisoned"`
+	Filtered    int       `json:"filtered"` // dropped by the date, shard and ignore filters
+	Uncaptioned int       `json:"uncaptioned"`
+	Captioned   int       `json:"captioned"`
+	Done        int       `json:"done"`
+}
+
+// done is the number of images that have a caption
+func (b backlog) done() int {
+	return b.Existing + b.Imported + b.Captioned
+}
+
+func (b backlog) log() {
+	logInfo("Backlog: %d uncaptioned, %d done, %d poisoned of %d images", b.Uncaptioned, b.done(), b.Poisoned, b.Images)
+}
+
+// writeJSON replaces the file, so a dashboard never reads a half written backlog
+func (b backlog) writeJSON(file string) error {
+	b.Time = time.Now()
+	b.Done = b.done()
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// watchImages scans the tree again every --interval and captions the new
+// images. The backlog is logged with every scan and written to --backlog.
+func watchImages(ol *hostPool, args args, state *runState, imported *skipList) {
+	if args.metricsAddr != "" {
+		startMetrics(args.metricsAddr)
+	}
+	for {
+		b, err := captionImages(ol, args, state, imported)
+		if err != nil {
+			logError("Scan failed: %v", err)
+		} else {
+			if b.Captioned > 0 {
+				logInfo("Captioned %d new images", b.Captioned)
+				b.log()
+				webhook.summary(&b)
+			}
+			if args.backlogFile != "" {
+				err = b.writeJSON(args.backlogFile)
+				if err != nil {
+					logError("Could not write backlog: %v", err)
+				}
+			}
+		}
+		if args.Summary != "" {
+			err = stats.writeJSON(args.Summary, args)
+			if err != nil {
+				logError("Could not write summary: %v", err)
+			}
+		}
+		time.Sleep(args.watchInterval)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const propfindBody = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">
+ <d:prop><d:resourcetype/><oc:fileid/><oc:comments-count/><nc:system-tags/></d:prop>
+</d:propfind>`
+
+// davEntry is a file of the listing with the Nextcloud properties
+type davEntry struct {
+	fileID   string
+	comments int
+	tags     int
+}
+
+// webdavStore lists and downloads the files of a WebDAV folder, dav:// is
+// http and davs:// https. The login is read from WEBDAV_USER and
+// WEBDAV_PASSWORD (an app password for Nextcloud).
+type webdavStore struct {
+	base     *url.URL // the folder of PATH
+	scheme   string
+	user     string
+	password string
+
+	mu      sync.Mutex
+	entries map[string]davEntry // by key, filled by list
+	tagIDs  map[string]string   // Nextcloud system tags by name
+}
+
+func newWebDAVStore(scheme string, rest string) (*webdavStore, error) {
+	httpScheme := "https"
+	if scheme == "dav" {
+		httpScheme = "http"
+	}
+	base, err := url.Parse(httpScheme + "://" + strings.TrimRight(rest, "/") + "/")
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV URL %q", scheme+"://"+rest)
+	}
+	return &webdavStore{
+		base:     base,
+		scheme:   scheme,
+		user:     os.Getenv("WEBDAV_USER"),
+		password: os.Getenv("WEBDAV_PASSWORD"),
+		entries:  map[string]davEntry{},
+	}, nil
+}
+
+func (s *webdavStore) url(key string) string {
+	return s.scheme + "://" + s.base.Host + s.base.Path + key
+}
+
+func (s *webdavStore) do(method string, u string, header map[string]string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, buf.Bytes(), nil
+}
+
+// propfind lists a collection (Depth 1) and returns the responses
+func (s *webdavStore) propfind(u string, body string) ([]davResponse, error) {
+	resp, data, err := s.do("PROPFIND", u, map[string]string{"Depth": "1", "Content-Type": "application/xml"}, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", u, resp.Status)
+	}
+	var ms struct {
+		Responses []davResponse `xml:"DAV: response"`
+	}
+	err = xml.Unmarshal(data, &ms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND response of %s: %w", u, err)
+	}
+	return ms.Responses, nil
+}
+
+type davResponse struct {
+	Href string `xml:"DAV: href"`
+	Prop struct {
+		Collection *struct{} `xml:"DAV: resourcetype>collection"`
+		FileID     string    `xml:"http://owncloud.org/ns fileid"`
+		Comments   string    `xml:"http://owncloud.org/ns comments-count"`
+		Tags       []string  `xml:"http://nextcloud.org/ns system-tags>system-tag"`
+		ID         string    `xml:"http://owncloud.org/ns id"`
+		Name       string    `xml:"http://owncloud.org/ns display-name"`
+	} `xml:"DAV: propstat>prop"`
+}
+
+// list walks the folders below the prefix, one PROPFIND per folder, and skips
+// hidden folders like the walk of local folders
+func (s *webdavStore) list(prefix string) ([]string, error) {
+	var keys []string
+	dirs := []string{strings.TrimLeft(prefix, "/")}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		responses, err := s.propfind(s.base.String()+(&url.URL{Path: dir}).EscapedPath(), propfindBody)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range responses {
+			p, err := url.PathUnescape(r.Href)
+			if err != nil {
+				continue
+			}
+			if u, err := url.Parse(p); err == nil && u.Host != "" {
+				p = u.Path
+			}
+			key, ok := strings.CutPrefix(p, s.base.Path)
+			key = strings.TrimRight(key, "/")
+			if !ok || key == strings.TrimRight(dir, "/") {
+				// the folder itself
+				continue
+			}
+			if r.Prop.Collection != nil {
+				if !strings.HasPrefix(path.Base(key), ".") {
+					dirs = append(dirs, key+"/")
+				}
+				continue
+			}
+			comments, _ := strconv.Atoi(r.Prop.Comments)
+			s.mu.Lock()
+			s.entries[key] = davEntry{fileID: r.Prop.FileID, comments: comments, tags: len(r.Prop.Tags)}
+			s.mu.Unlock()
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *webdavStore) fileURL(key string) string {
+	return s.base.String() + strings.TrimPrefix((&url.URL{Path: key}).EscapedPath(), "/")
+}
+
+func (s *webdavStore) get(key string) ([]byte, error) {
+	resp, data, err := s.do(http.MethodGet, s.fileURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", s.url(key), resp.Status)
+	}
+	return data, nil
+}
+
+func (s *webdavStore) put(key string, data []byte) error {
+	resp, _, err := s.do(http.MethodPut, s.fileURL(key), map[string]string{"Content-Type": "text/plain; charset=utf-8"}, data)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", s.url(key), resp.Status)
+	}
+	return nil
+}
+
+// nextcloudAPI is the DAV root of Nextcloud (https://host/remote.php/dav) that
+// has the comments and system tags
+func (s *webdavStore) nextcloudAPI() (string, error) {
+	root, _, ok := strings.Cut(s.base.Path, "/remote.php/dav/")
+	if !ok {
+		return "", fmt.Errorf("%s is no Nextcloud WebDAV URL (.../remote.php/dav/files/USER/...)", s.url(""))
+	}
+	return s.base.Scheme + "://" + s.base.Host + root + "/remote.php/dav", nil
+}
+
+// hasCaption checks if the file has a comment (or system tags with --mode tags)
+func (s *webdavStore) hasCaption(args args, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.entries[key]
+	if args.Mode == "tags" {
+		return entry.tags > 0
+	}
+	return entry.comments > 0
+}
+
+// writeCaption adds the caption as a comment of the file, or assigns the tags
+// of --mode tags as collaborative tags, which are searchable in the web UI
+func (s *webdavStore) writeCaption(args args, key string, caption string) error {
+	api, err := s.nextcloudAPI()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	fileID := s.entries[key].fileID
+	s.mu.Unlock()
+	if fileID == "" {
+		return fmt.Errorf("no file id for %s", s.url(key))
+	}
+	if args.Mode != "tags" {
+		body, _ := json.Marshal(map[string]string{"actorType": "users", "verb": "comment", "message": caption})
+		resp, _, err := s.do(http.MethodPost, api+"/comments/files/"+fileID, map[string]string{"Content-Type": "application/json"}, body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("could not comment %s: %s", s.url(key), resp.Status)
+		}
+		return nil
+	}
+	for _, tag := range normalizeTags(args, caption) {
+		id, err := s.systemTag(api, tag)
+		if err != nil {
+			return err
+		}
+		resp, _, err := s.do(http.MethodPut, api+"/systemtags-relations/files/"+fileID+"/"+id, nil, nil)
+		if err != nil {
+			return err
+		}
+		// 409 means the tag is assigned already
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+			return fmt.Errorf("could not tag %s with %q: %s", s.url(key), tag, resp.Status)
+		}
+	}
+	return nil
+}
+
+// systemTag returns the id of the collaborative tag and creates it if needed
+func (s *webdavStore) systemTag(api string, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tagIDs == nil {
+		responses, err := s.propfind(api+"/systemtags/", `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:prop><oc:id/><oc:display-name/></d:prop></d:propfind>`)
+		if err != nil {
+			return "", err
+		}
+		s.tagIDs = map[string]string{}
+		for _, r := range responses {
+			if r.Prop.ID != "" {
+				s.tagIDs[strings.ToLower(r.Prop.Name)] = r.Prop.ID
+			}
+		}
+	}
+	if id, ok := s.tagIDs[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+	body, _ := json.Marshal(map[string]any{"name": name, "userVisible": true, "userAssignable": true, "canAssign": true})
+	resp, _, err := s.do(http.MethodPost, api+"/systemtags/", map[string]string{"Content-Type": "application/json"}, body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("could not create the tag %q: %s", name, resp.Status)
+	}
+	id := path.Base(resp.Header.Get("Content-Location"))
+	s.tagIDs[strings.ToLower(name)] = id
+	return id, nil
+}
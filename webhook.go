@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookAttempts is how often a payload is sent before it is dropped
+const webhookAttempts = 3
+
+// webhookImage is the payload for every captioned image
+type webhookImage struct {
+	Event   string            `json:"event"` // "image"
+	Path    string            `json:"path"`
+	Caption string            `json:"caption"`
+	Answers map[string]string `json:"answers,omitempty"` // the --extra-prompt answers by suffix
+	Counts  *objectCounts     `json:"counts,omitempty"`
+	Rating  string            `json:"rating,omitempty"`
+	Model   string            `json:"model"`
+	Seconds float64           `json:"duration_seconds"`
+}
+
+// webhookSummary is the payload at the end of a run (or a scan of watch)
+type webhookSummary struct {
+	Event   string   `json:"event"` // "summary"
+	Version string   `json:"version"`
+	Backlog *backlog `json:"backlog,omitempty"`
+	*runStats
+}
+
+// webhookNotifier POSTs the payloads to --webhook in the background, a few
+// at a time so a slow automation does not hold up the captioning
+type webhookNotifier struct {
+	url      string
+	client   *http.Client
+	inflight chan struct{}
+	wg       sync.WaitGroup
+}
+
+var webhook *webhookNotifier // nil without --webhook
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: 30 * time.Second}, inflight: make(chan struct{}, 4)}
+}
+
+// notifyImage sends the captioned image to --webhook and --mqtt
+func notifyImage(args args, res result, answers []result, took time.Duration) {
+	webhook.image(args, res, answers, took)
+	mqttOut.image(args, res, answers)
+}
+
+// image sends the caption and the answers of the image
+func (w *webhookNotifier) image(args args, res result, answers []result, took time.Duration) {
+	if w == nil {
+		return
+	}
+	payload := webhookImage{Event: "image", Path: res.Path, Caption: res.Caption, Counts: res.Counts, Rating: res.Rating, Model: args.Model, Seconds: took.Seconds()}
+	for _, answer := range answers {
+		if payload.Answers == nil {
+			payload.Answers = map[string]string{}
+		}
+		payload.Answers[answer.Suffix] = answer.Caption
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.inflight <- struct{}{}
+		defer func() { <-w.inflight }()
+		w.post(payload)
+	}()
+}
+
+// summary waits for the image payloads and sends the statistics of the run,
+// watch adds the backlog of the scan
+func (w *webhookNotifier) summary(b *backlog) {
+	if w == nil {
+		return
+	}
+	w.wg.Wait()
+	stats.mu.Lock()
+	stats.update()
+	data, err := json.Marshal(webhookSummary{Event: "summary", Version: strings.TrimSpace(fullVersion), Backlog: b, runStats: stats})
+	stats.mu.Unlock()
+	if err != nil {
+		logError("Could not encode the webhook summary: %v", err)
+		return
+	}
+	w.send(data)
+}
+
+func (w *webhookNotifier) post(payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logError("Could not encode the webhook payload: %v", err)
+		return
+	}
+	w.send(data)
+}
+
+// send POSTs the JSON and tries again after network errors and 5xx answers
+func (w *webhookNotifier) send(data []byte) {
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+		var resp *http.Response
+		resp, err = w.client.Post(w.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return
+		}
+		err = fmt.Errorf("%s", resp.Status)
+		if resp.StatusCode < 500 {
+			break
+		}
+	}
+	logError("Webhook %s failed: %v", redactURL(w.url), err)
+}
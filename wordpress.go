@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wordpressUserEnv     = "WORDPRESS_USER"
+	wordpressPasswordEnv = "WORDPRESS_APP_PASSWORD"
+)
+
+// wordpressPreview is the size of the media item that is downloaded instead
+// of the full upload if WordPress generated it
+const wordpressPreview = "large"
+
+// wordpressMedia is the part of a media item of the REST API we need
+type wordpressMedia struct {
+	ID           int    `json:"id"`
+	SourceURL    string `json:"source_url"`
+	AltText      string `json:"alt_text"`
+	MediaDetails struct {
+		Sizes map[string]struct {
+			SourceURL string `json:"source_url"`
+		} `json:"sizes"`
+	} `json:"media_details"`
+}
+
+// wordpressSite talks to the REST API of the site with an application
+// password (Users > Profile > Application Passwords)
+type wordpressSite struct {
+	api      string // https://example.com/wp-json/wp/v2
+	user     string
+	password string
+}
+
+func newWordPressSite(args args) (*wordpressSite, error) {
+	site := strings.TrimRight(args.WordPress, "/")
+	if !isURL(site) {
+		return nil, fmt.Errorf("--wordpress needs the URL of the site like https://example.com, not %q", args.WordPress)
+	}
+	s := &wordpressSite{
+		api:      site + "/wp-json/wp/v2",
+		user:     cmp.Or(args.WordPressUser, os.Getenv(wordpressUserEnv)),
+		password: os.Getenv(wordpressPasswordEnv),
+	}
+	if s.user == "" || s.password == "" {
+		return nil, fmt.Errorf("--wordpress needs --wordpress-user (or %s) and %s", wordpressUserEnv, wordpressPasswordEnv)
+	}
+	return s, nil
+}
+
+func (s *wordpressSite) request(method string, u string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.SetBasicAuth(s.user, s.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var wpErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &wpErr) == nil && wpErr.Message != "" {
+			return nil, nil, fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, wpErr.Message)
+		}
+		return nil, nil, fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	return resp, data, nil
+}
+
+// media lists all images of the media library, page by page
+func (s *wordpressSite) media() ([]wordpressMedia, error) {
+	var items []wordpressMedia
+	for page := 1; ; page++ {
+		resp, data, err := s.request(http.MethodGet, fmt.Sprintf("%s/media?media_type=image&context=edit&per_page=100&page=%d", s.api, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []wordpressMedia
+		err = json.Unmarshal(data, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid media list: %w", err)
+		}
+		items = append(items, batch...)
+		pages, _ := strconv.Atoi(resp.Header.Get("X-WP-TotalPages"))
+		if page >= pages || len(batch) == 0 {
+			return items, nil
+		}
+	}
+}
+
+// setAlt PATCHes the alt text of the media item
+func (s *wordpressSite) setAlt(id int, alt string) error {
+	body, _ := json.Marshal(map[string]string{"alt_text": alt})
+	_, _, err := s.request(http.MethodPatch, fmt.Sprintf("%s/media/%d", s.api, id), body)
+	return err
+}
+
+// syncWordPress generates the alt texts of the images in the media library of
+// --wordpress that have none (all with --force) and writes them back
+func syncWordPress(ol *hostPool, args args) error {
+	site, err := newWordPressSite(args)
+	if err != nil {
+		return err
+	}
+	items, err := site.media()
+	if err != nil {
+		return err
+	}
+	b := backlog{Images: len(items)}
+	var todo []wordpressMedia
+	for _, item := range items {
+		if !args.Force && strings.TrimSpace(item.AltText) != "" {
+			b.Existing++
+			continue
+		}
+		todo = append(todo, item)
+	}
+	stats.skipped(b)
+	logVerbose("Found %d images in the media library of %s, %d without alt text", b.Images, args.WordPress, len(todo))
+
+	prompt := altPrompt(args)
+	var prog *progress
+	if args.Progress {
+		prog = startProgress(len(todo))
+	}
+	work := make(chan wordpressMedia)
+	var wg sync.WaitGroup
+	for w := 0; w < args.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				start := time.Now()
+				alt, err := wordpressAlt(ol, args, prompt, item)
+				stats.imageDone(item.SourceURL, time.Since(start), err)
+				prog.step()
+				if err != nil {
+					logError("Failed %s: %v", logPath(item.SourceURL), err)
+					continue
+				}
+				printResult(args, result{Path: item.SourceURL, Caption: alt}, "")
+				notifyImage(args, result{Path: item.SourceURL, Caption: alt}, nil, time.Since(start))
+				if !args.DryRun {
+					err = site.setAlt(item.ID, alt)
+					if err != nil {
+						log.Fatalf("Could not write alt text: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	for _, item := range todo {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+	prog.finish()
+	return nil
+}
+
+// wordpressAlt downloads the large size of the image (or the upload) and
+// captions it with the alt text prompt
+func wordpressAlt(ol *hostPool, args args, prompt string, item wordpressMedia) (string, error) {
+	u := item.SourceURL
+	if size, ok := item.MediaDetails.Sizes[wordpressPreview]; ok && size.SourceURL != "" {
+		u = size.SourceURL
+	}
+	imgData, err := fetchURL(u)
+	if err == nil {
+		imgData, err = Preprocess(imgData, args.steps)
+	}
+	if err != nil {
+		return "", err
+	}
+	return suggestAlt(ol, args, prompt, imgData)
+}
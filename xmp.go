@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var xmpStyles = []string{"digikam", "lightroom"}
+
+func isValidXMPStyle(style string) bool {
+	return contains(xmpStyles, style)
+}
+
+// xmpToolkit marks the sidecars that capollama wrote, other sidecars are not replaced
+const xmpToolkit = appName
+
+const keywordsSuffix = ".keywords.txt"
+
+const keywordsPrompt = "List 5 to 15 keywords for this image as a hierarchy from the general category to the specific keyword, one per line with the levels separated by \" > \", like \"Subject > People > Anna\", \"Places > Beach\" or \"Animals > Dogs > Labrador\". Answer only with the keywords."
+
+// xmpSidecar is the sidecar of the image, digiKam names it IMAGE.jpg.xmp and
+// Lightroom IMAGE.xmp
+func xmpSidecar(args args, imagePath string) string {
+	if args.XMP == "lightroom" {
+		return outputFile(imagePath, ".xmp")
+	}
+	return imagePath + ".xmp"
+}
+
+// xmpTagsSuffix is the suffix of the prompt with the tags of the sidecar
+func xmpTagsSuffix(args args) string {
+	if args.XMPKeywords {
+		return keywordsSuffix
+	}
+	return args.XMPTags
+}
+
+// sidecarTags returns the tags of the sidecar as paths from the root: the
+// caption with --mode tags or the answer of the --xmp-tags (or --xmp-keywords)
+// prompt. Tags with a slash or > (Animals > Dog) are hierarchical already.
+func sidecarTags(args args, path string, caption string, answers []result) [][]string {
+	text := tagsAnswer(args, path, xmpTagsSuffix(args), caption, answers)
+	if text == "" {
+		return nil
+	}
+	var root []string
+	for _, part := range strings.FieldsFunc(args.XMPTagRoot, func(r rune) bool { return r == '/' || r == '>' || r == '|' }) {
+		if part = strings.TrimSpace(part); part != "" {
+			root = append(root, part)
+		}
+	}
+	var tags [][]string
+	for _, tag := range normalizeTags(args, text) {
+		hierarchy := append([]string{}, root...)
+		for _, part := range strings.FieldsFunc(tag, func(r rune) bool { return r == '/' || r == '>' || r == '|' }) {
+			if part = strings.TrimSpace(part); part != "" {
+				hierarchy = append(hierarchy, part)
+			}
+		}
+		if len(hierarchy) == len(root) {
+			// the tag was only separators
+			continue
+		}
+		tags = append(tags, hierarchy)
+	}
+	return tags
+}
+
+// tagsAnswer is the caption with --mode tags, otherwise the answer of the extra
+// prompt with the suffix (or its file if the image was not asked again)
+func tagsAnswer(args args, path string, suffix string, caption string, answers []result) string {
+	if args.Mode == "tags" {
+		return caption
+	}
+	if suffix == "" {
+		return ""
+	}
+	for _, answer := range answers {
+		if answer.Suffix == suffix {
+			return answer.Caption
+		}
+	}
+	text, _ := readCaption(outputFile(path, suffix))
+	return text
+}
+
+// writeSidecar writes the caption and the tags to the XMP sidecar of the
+// image. Both read the description from dc:description, digiKam reads the
+// tags from digiKam:TagsList and Lightroom builds its keyword tree from
+// lr:hierarchicalSubject.
+func writeSidecar(args args, path string, caption string, tags [][]string) error {
+	file := xmpSidecar(args, path)
+	existing, err := os.ReadFile(file)
+	if err == nil && !bytes.Contains(existing, []byte(`x:xmptk="`+xmpToolkit)) {
+		logInfo("Not replacing %s, it was not written by %s", file, appName)
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	fmt.Fprintf(&b, "<x:xmpmeta xmlns:x=\"adobe:ns:meta/\" x:xmptk=\"%s %s\">\n", xmpToolkit, strings.TrimSpace(fullVersion))
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:digiKam=\"http://www.digikam.org/ns/1.0/\"\n")
+	b.WriteString("    xmlns:lr=\"http://ns.adobe.com/lightroom/1.0/\">\n")
+	if args.Mode != "tags" && caption != "" {
+		writeXMPList(&b, "dc:description", "rdf:Alt", []string{caption}, ` xml:lang="x-default"`)
+	}
+	if len(tags) > 0 {
+		var subjects, digikam, lightroom []string
+		for _, tag := range tags {
+			if args.XMP == "lightroom" {
+				// Lightroom exports the parents of keywords as keywords too
+				for _, part := range tag {
+					if !contains(subjects, part) {
+						subjects = append(subjects, part)
+					}
+				}
+			} else {
+				subjects = append(subjects, tag[len(tag)-1])
+			}
+			digikam = append(digikam, strings.Join(tag, "/"))
+			lightroom = append(lightroom, strings.Join(tag, "|"))
+		}
+		writeXMPList(&b, "dc:subject", "rdf:Bag", subjects, "")
+		if args.XMP == "digikam" {
+			writeXMPList(&b, "digiKam:TagsList", "rdf:Seq", digikam, "")
+		}
+		writeXMPList(&b, "lr:hierarchicalSubject", "rdf:Bag", lightroom, "")
+	}
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>\n")
+
+	err = os.WriteFile(file, []byte(b.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+// writeXMPList writes an XMP array property with the escaped items
+func writeXMPList(b *strings.Builder, property string, kind string, items []string, attr string) {
+	fmt.Fprintf(b, "   <%s>\n    <%s>\n", property, kind)
+	for _, item := range items {
+		fmt.Fprintf(b, "     <rdf:li%s>", attr)
+		xml.EscapeText(b, []byte(item))
+		b.WriteString("</rdf:li>\n")
+	}
+	fmt.Fprintf(b, "    </%s>\n   </%s>\n", kind, property)
+}